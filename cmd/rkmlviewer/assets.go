@@ -0,0 +1,110 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+//go:generate sh -c "rm -rf embedded && mkdir -p embedded/textures && cp -r ../../assets/shaders ../../assets/data embedded/ && cp ../../assets/textures/font_7x13.png ../../assets/textures/default_icon.png embedded/textures/"
+
+// copies of the shaders, data and small default textures built into the binary, used when an asset
+// is not found in the search path. refresh them with "go generate" after changing ../../assets
+//
+//go:embed embedded
+var embeddedAssets embed.FS
+
+// directories searched in order for assets, set by setAssetSearchPath
+var assetDirs []string
+
+// Config is the per-user configuration file
+type Config struct {
+	Assets string `json:"assets"` // asset directory, searched after -assets
+	Tiles  string `json:"tiles"`  // tile directory or .mbtiles file, used if -tiles isn't given
+	DEM    string `json:"dem"`    // elevation file or directory, used if -dem isn't given
+}
+
+// returns the path of the per-user configuration file
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rkmlviewer", "config.json"), nil
+}
+
+// reads the configuration file. a missing file is not an error
+func loadConfig() (Config, error) {
+	config := Config{}
+
+	path, err := configPath()
+	if err != nil {
+		return config, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	return config, err
+}
+
+// sets the asset search path: the -assets flag, the config file entry, $RKMLVIEWER_ASSETS,
+// the assets directory next to the executable or two levels above it (the repository layout),
+// then the same relative to the working directory
+func setAssetSearchPath(flagDir string, config Config) {
+	dirs := []string{flagDir, config.Assets, os.Getenv("RKMLVIEWER_ASSETS")}
+	if exe, err := os.Executable(); err == nil {
+		exeDir := filepath.Dir(exe)
+		dirs = append(dirs, filepath.Join(exeDir, "assets"), filepath.Join(exeDir, "..", "..", "assets"))
+	}
+	dirs = append(dirs, "assets", filepath.Join("..", "..", "assets"))
+
+	assetDirs = []string{}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir == "" || seen[filepath.Clean(dir)] {
+			continue
+		}
+		seen[filepath.Clean(dir)] = true
+		assetDirs = append(assetDirs, dir)
+	}
+}
+
+// returns the file of an asset (a slash separated path like "textures/font_7x13.png") in the search path
+func findAsset(name string) (string, bool) {
+	for _, dir := range assetDirs {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// returns true if an asset is in the search path or built in
+func assetExists(name string) bool {
+	if _, ok := findAsset(name); ok {
+		return true
+	}
+	_, err := embeddedAssets.Open(path.Join("embedded", name))
+	return err == nil
+}
+
+// opens an asset from the search path, falling back to the built in copy
+func openAsset(name string) (io.ReadCloser, error) {
+	if p, ok := findAsset(name); ok {
+		return os.Open(p)
+	}
+	if f, err := embeddedAssets.Open(path.Join("embedded", name)); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("asset %s not found in %v and not built in", name, assetDirs)
+}
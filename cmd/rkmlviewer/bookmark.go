@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Bookmark is a saved camera viewpoint, including the rotation of the earth
+type Bookmark struct {
+	Pos    mgl32.Vec3 `json:"pos"`
+	Front  mgl32.Vec3 `json:"front"`
+	Up     mgl32.Vec3 `json:"up"`
+	Yaw    float32    `json:"yaw"`
+	Pitch  float32    `json:"pitch"`
+	AngleZ float32    `json:"angleZ"`
+}
+
+// stores the numbered bookmarks (0-9)
+var bookmarks struct {
+	sync.Mutex
+	saved map[int]Bookmark
+}
+
+// bookmark chosen in the gui, recalled by the render loop since it owns the camera
+var queuedBookmark struct {
+	sync.Mutex
+	n       int
+	pending bool
+}
+
+// returns the path of the per-user bookmark file
+func bookmarkPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rkmlviewer", "bookmarks.json"), nil
+}
+
+// reads the bookmark file. a missing file is not an error
+func loadBookmarks() error {
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+
+	bookmarks.saved = make(map[int]Bookmark)
+
+	path, err := bookmarkPath()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &bookmarks.saved)
+}
+
+// writes the bookmark file, must be called with bookmarks locked
+func saveBookmarks() error {
+	path, err := bookmarkPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(bookmarks.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// stores the current camera in bookmark n and writes the bookmark file
+func setBookmark(n int) error {
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+
+	bookmarks.saved[n] = Bookmark{
+		Pos:    camera.Pos,
+		Front:  camera.Front,
+		Up:     camera.Up,
+		Yaw:    mouse.yaw,
+		Pitch:  mouse.pitch,
+		AngleZ: angleZ,
+	}
+	return saveBookmarks()
+}
+
+// moves the camera to bookmark n, returns false if it is not set
+func recallBookmark(n int) bool {
+	bookmarks.Lock()
+	b, ok := bookmarks.saved[n]
+	bookmarks.Unlock()
+	if !ok {
+		return false
+	}
+
+	flight.active = false
+	camera.Pos = b.Pos
+	camera.Front = b.Front
+	camera.Up = b.Up
+	mouse.yaw = b.Yaw
+	mouse.pitch = b.Pitch
+	mouse.firstMouse = true
+	angleZ = b.AngleZ
+	return true
+}
+
+// queues bookmark n to be recalled at the next frame, for the gui goroutine
+func queueBookmark(n int) {
+	queuedBookmark.Lock()
+	queuedBookmark.n, queuedBookmark.pending = n, true
+	queuedBookmark.Unlock()
+}
+
+// recalls the bookmark queued by the gui, if there is one
+func recallQueuedBookmark() {
+	queuedBookmark.Lock()
+	n, pending := queuedBookmark.n, queuedBookmark.pending
+	queuedBookmark.pending = false
+	queuedBookmark.Unlock()
+	if pending {
+		recallBookmark(n)
+	}
+}
+
+// returns the set bookmark numbers in order, with a short description of each
+func bookmarkList() ([]int, []string) {
+	bookmarks.Lock()
+	defer bookmarks.Unlock()
+
+	keys := []int{}
+	for n := range bookmarks.saved {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+
+	descriptions := []string{}
+	for _, n := range keys {
+		b := bookmarks.saved[n]
+		descriptions = append(descriptions, fmt.Sprintf("pos (%.2f, %.2f, %.2f) dist %.2f rotation %.1f",
+			b.Pos[0], b.Pos[1], b.Pos[2], b.Pos.Len(), b.AngleZ))
+	}
+	return keys, descriptions
+}
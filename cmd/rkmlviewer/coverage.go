@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+)
+
+const (
+	// number of segments of a footprint circle, and every how many of its points a line of the cone is drawn to
+	coverageSegments = 120
+	coverageConeStep = 8
+
+	// moving cones (of timed tracks or inertial features) are rebuilt when the simulation time has moved this far
+	coverageUpdateInterval = time.Second
+)
+
+var (
+	// minimum elevation angles in degrees offered in the gui for cones added there
+	coverageElevations = []float64{0, 5, 10, 15, 20, 30}
+
+	// cones turned on or off in the gui, by tree path. they replace the cone of the feature's ExtendedData.
+	// guarded by mutex
+	coneOverrides = map[string]coneSpec{}
+
+	// selected features with a cone, collected by interpretSelected
+	coverageSources []coverageSource
+)
+
+// coneSpec is a coverage cone: its half-angle from nadir, or the minimum elevation seen from the ground
+type coneSpec struct {
+	off          bool
+	halfAngle    float64
+	minElevation float64
+	byElevation  bool
+}
+
+// coverageSource is a selected feature with a coverage cone
+type coverageSource struct {
+	folder Folder
+	frame  string
+	spec   coneSpec
+	color  [3]float32
+}
+
+// coverage is the coverage cones and footprints of the selected features in one vertex buffer,
+// in the line vertex layout
+type coverage struct {
+	vertexArray  uint32
+	vertexBuffer uint32
+	count        int32
+
+	// simulation time the buffer was built at, and whether any cone moves with it
+	built  time.Time
+	moving bool
+}
+
+// returns the cone a feature sets with an ExtendedData "coneHalfAngle" or "coneMinElevation" (degrees).
+// values out of range are ignored
+func featureCone(f Folder) (coneSpec, bool) {
+	if v, ok := extendedData(f, "coneHalfAngle"); ok {
+		if h, err := strconv.ParseFloat(v, 64); err == nil && h > 0 && h < 90 {
+			return coneSpec{halfAngle: h}, true
+		}
+	}
+	if v, ok := extendedData(f, "coneMinElevation"); ok {
+		if e, err := strconv.ParseFloat(v, 64); err == nil && e >= 0 && e < 90 {
+			return coneSpec{minElevation: e, byElevation: true}, true
+		}
+	}
+	return coneSpec{}, false
+}
+
+// returns the cone of the feature at path: the one set in the gui, else the one of its ExtendedData.
+// mutex must be held
+func pathCone(f Folder, path []string) (coneSpec, bool) {
+	if spec, ok := coneOverrides[strings.Join(path, "/")]; ok {
+		return spec, !spec.off
+	}
+	return featureCone(f)
+}
+
+// turns the cone of the feature at path off if it has one, else on with the minimum elevation chosen in the gui
+func toggleCone(path []string) {
+	f, ok := lookupFolder(path)
+	if !ok {
+		return
+	}
+	mutex.Lock()
+	if _, on := pathCone(f, path); on {
+		coneOverrides[strings.Join(path, "/")] = coneSpec{off: true}
+	} else {
+		coneOverrides[strings.Join(path, "/")] = coneSpec{minElevation: state.coverageElevation, byElevation: true}
+	}
+	mutex.Unlock()
+	state.resetting = true
+}
+
+// returns the position (m, earth fixed) a feature's cone is drawn from: its point, the position of its
+// track at the simulation time (or the closest end), or the first coordinate of an untimed track.
+// inertial positions are turned like the drawn inertial features, so the cone stays on the feature.
+// moving is true if the position changes with the simulation time
+func conePosition(f Folder, frame string) (pos [3]float64, moving bool, ok bool) {
+	if len(f.Track.Coords) == 0 && f.Point.Coordinates == "" {
+		return pos, false, false
+	}
+	track, err := featurePositions(f, frameECEF)
+	if err != nil {
+		return pos, false, false
+	}
+	switch {
+	case track[0].when.IsZero() || !simTime.After(track[0].when):
+		pos = track[0].pos
+	case !simTime.Before(track[len(track)-1].when):
+		pos = track[len(track)-1].pos
+	default:
+		pos, _ = positionAt(track, simTime)
+	}
+	moving = !track[0].when.IsZero()
+
+	if frame == frameECI {
+		sin, cos := math.Sincos(-earthRotation() * (math.Pi / 180))
+		pos = [3]float64{pos[0]*cos - pos[1]*sin, pos[0]*sin + pos[1]*cos, pos[2]}
+		moving = moving || state.siderealRotation
+	}
+	return pos, moving, true
+}
+
+// returns the points (m, earth fixed) where the cone from p meets the ellipsoid, around its axis towards
+// the earth center. false if p is not above the ground
+func footprint(p [3]float64, spec coneSpec) ([][3]float64, bool) {
+	r := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])
+	// stretch the ellipsoid into a sphere of the equatorial radius, where the horizon is the same all around
+	ps := [3]float64{p[0], p[1], p[2] * a / polarRadius}
+	rs := math.Sqrt(ps[0]*ps[0] + ps[1]*ps[1] + ps[2]*ps[2])
+	if rs <= a {
+		return nil, false
+	}
+	horizon := math.Asin(a / rs)
+
+	// nadir and two directions across it
+	n := [3]float64{-p[0] / r, -p[1] / r, -p[2] / r}
+	ns := [3]float64{-ps[0] / rs, -ps[1] / rs, -ps[2] / rs}
+	axis := [3]float64{0, 0, 1}
+	if math.Abs(n[2]) > 0.9 {
+		axis = [3]float64{1, 0, 0}
+	}
+	u := normalize3(cross3(n, axis))
+	v := cross3(n, u)
+
+	sinEta, cosEta := math.Sincos(spec.halfAngle * (math.Pi / 180))
+	points := [][3]float64{}
+	for i := 0; i < coverageSegments; i++ {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / coverageSegments)
+		w := [3]float64{u[0]*cos + v[0]*sin, u[1]*cos + v[1]*sin, u[2]*cos + v[2]*sin}
+
+		// the plane of the nadir and w passes through the earth center, stretched too. angles from the nadir
+		// are measured in the stretched plane, where the elevation and horizon follow from the sphere
+		ws := [3]float64{w[0], w[1], w[2] * a / polarRadius}
+		along := ws[0]*ns[0] + ws[1]*ns[1] + ws[2]*ns[2]
+		across := normalize3([3]float64{ws[0] - ns[0]*along, ws[1] - ns[1]*along, ws[2] - ns[2]*along})
+		d := [3]float64{}
+		for k := range d {
+			d[k] = n[k]*cosEta + w[k]*sinEta
+		}
+		d[2] *= a / polarRadius
+		angle := math.Atan2(d[0]*across[0]+d[1]*across[1]+d[2]*across[2], d[0]*ns[0]+d[1]*ns[1]+d[2]*ns[2])
+		if spec.byElevation {
+			angle = math.Asin(a / rs * math.Cos(spec.minElevation*(math.Pi/180)))
+		}
+		// cut at the horizon, where the ray just touches the ellipsoid
+		angle = math.Min(angle, horizon)
+
+		sinA, cosA := math.Sincos(angle)
+		for k := range d {
+			d[k] = ns[k]*cosA + across[k]*sinA
+		}
+		d[2] *= polarRadius / a
+		points = append(points, rayEllipsoid(p, d))
+	}
+	return points, true
+}
+
+// returns where a ray from p in direction d first meets the ellipsoid, or the point of the ray closest to it if it misses
+func rayEllipsoid(p [3]float64, d [3]float64) [3]float64 {
+	// stretch the ellipsoid into a sphere of the equatorial radius
+	ps := [3]float64{p[0], p[1], p[2] * a / polarRadius}
+	ds := [3]float64{d[0], d[1], d[2] * a / polarRadius}
+	aa := ds[0]*ds[0] + ds[1]*ds[1] + ds[2]*ds[2]
+	bb := ps[0]*ds[0] + ps[1]*ds[1] + ps[2]*ds[2]
+	cc := ps[0]*ps[0] + ps[1]*ps[1] + ps[2]*ps[2] - a*a
+	t := -bb / aa
+	if disc := bb*bb - aa*cc; disc >= 0 {
+		t = (-bb - math.Sqrt(disc)) / aa
+	}
+	return [3]float64{p[0] + d[0]*t, p[1] + d[1]*t, p[2] + d[2]*t}
+}
+
+// returns the cross product of two vectors
+func cross3(p [3]float64, q [3]float64) [3]float64 {
+	return [3]float64{p[1]*q[2] - p[2]*q[1], p[2]*q[0] - p[0]*q[2], p[0]*q[1] - p[1]*q[0]}
+}
+
+// returns a vector scaled to length 1
+func normalize3(p [3]float64) [3]float64 {
+	l := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])
+	return [3]float64{p[0] / l, p[1] / l, p[2] / l}
+}
+
+// appends the vertices of a cone and its footprint: the footprint circle just above the ground and
+// lines from the apex to some of its points
+func appendCone(vertices []float32, apex [3]float64, points [][3]float64, c [3]float32) []float32 {
+	vertex := func(p [3]float64, lift float64) []float32 {
+		return []float32{float32(p[0] / a * lift), float32(p[1] / a * lift), float32(p[2] / a * lift), c[0], c[1], c[2]}
+	}
+	for i := range points {
+		vertices = append(vertices, vertex(points[i], radius+mapLineLift)...)
+		vertices = append(vertices, vertex(points[(i+1)%len(points)], radius+mapLineLift)...)
+		if i%coverageConeStep == 0 {
+			vertices = append(vertices, vertex(apex, 1)...)
+			vertices = append(vertices, vertex(points[i], radius+mapLineLift)...)
+		}
+	}
+	return vertices
+}
+
+// (re)builds the cone vertex buffer from the selected features at the simulation time
+func (c *coverage) build() {
+	vertices := []float32{}
+	c.moving = false
+	for _, s := range coverageSources {
+		apex, moving, ok := conePosition(s.folder, s.frame)
+		if !ok {
+			continue
+		}
+		c.moving = c.moving || moving
+		if points, ok := footprint(apex, s.spec); ok {
+			vertices = appendCone(vertices, apex, points, s.color)
+		}
+	}
+	c.count = int32(len(vertices) / 6)
+	c.built = simTime
+
+	if c.vertexArray == 0 {
+		c.vertexArray, c.vertexBuffer = makeVaoColoredLines(vertices, nil, 6*4)
+		return
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, c.vertexBuffer)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.DYNAMIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+}
+
+// draws the cones with the object program, which must be in use. moving cones are rebuilt first
+// when the simulation time has moved on
+func (c *coverage) draw(program *shaderProgram) {
+	if c.moving {
+		if d := simTime.Sub(c.built); d >= coverageUpdateInterval || d <= -coverageUpdateInterval {
+			c.build()
+		}
+	}
+	if c.count == 0 {
+		return
+	}
+	program.set("highlight", false)
+	gl.BindVertexArray(c.vertexArray)
+	gl.DrawArrays(gl.LINES, 0, c.count)
+}
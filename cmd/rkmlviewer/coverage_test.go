@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// returns the distance between two points
+func distance3(p [3]float64, q [3]float64) float64 {
+	return math.Sqrt((p[0]-q[0])*(p[0]-q[0]) + (p[1]-q[1])*(p[1]-q[1]) + (p[2]-q[2])*(p[2]-q[2]))
+}
+
+func TestRayEllipsoid(t *testing.T) {
+	tests := []struct {
+		name string
+		p, d [3]float64
+		want [3]float64
+	}{
+		{"nadir at the equator", geodeticToECEF(0, 0, 500000), [3]float64{-1, 0, 0}, geodeticToECEF(0, 0, 0)},
+		{"nadir at the north pole", geodeticToECEF(90, 0, 500000), [3]float64{0, 0, -1}, geodeticToECEF(90, 0, 0)},
+		{"straight down at 45 north", geodeticToECEF(45, 30, 500000),
+			[3]float64{-math.Cos(math.Pi/4) * math.Cos(math.Pi/6), -math.Cos(math.Pi/4) * math.Sin(math.Pi/6), -math.Sin(math.Pi / 4)},
+			geodeticToECEF(45, 30, 0)},
+		{"unnormalized direction", geodeticToECEF(0, 90, 20000000), [3]float64{0, -1000, 0}, geodeticToECEF(0, 90, 0)},
+		{"starting on the ground", geodeticToECEF(-30, 60, 0), [3]float64{0, 0, 1}, geodeticToECEF(-30, 60, 0)},
+		{"missing beside the equator", [3]float64{2 * a, -a, 0}, [3]float64{0, 1, 0}, [3]float64{2 * a, 0, 0}},
+		{"missing over the pole", [3]float64{-a, 0, polarRadius + 1000}, [3]float64{1, 0, 0}, [3]float64{0, 0, polarRadius + 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rayEllipsoid(tt.p, tt.d); distance3(got, tt.want) > 1e-3 {
+				t.Errorf("rayEllipsoid(%v, %v) = %v, want %v", tt.p, tt.d, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	tests := []struct {
+		name          string
+		lat, lon, alt float64
+		spec          coneSpec
+	}{
+		{"horizon from low orbit over the equator", 0, 0, 500000, coneSpec{byElevation: true}},
+		{"horizon from low orbit at 45 north", 45, 30, 500000, coneSpec{byElevation: true}},
+		{"horizon from low orbit near the pole", 89, -60, 500000, coneSpec{byElevation: true}},
+		{"horizon from medium orbit", -60, 100, 20000000, coneSpec{byElevation: true}},
+		{"horizon from geostationary orbit", 0, -75, 35786000, coneSpec{byElevation: true}},
+		{"10 degrees elevation from low orbit", 45, 30, 500000, coneSpec{minElevation: 10, byElevation: true}},
+		{"30 degrees elevation from geostationary orbit", 0, -75, 35786000, coneSpec{minElevation: 30, byElevation: true}},
+		{"20 degree half-angle", 45, 30, 500000, coneSpec{halfAngle: 20}},
+		{"90 degree half-angle cut at the horizon", 45, 30, 500000, coneSpec{halfAngle: 90}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := geodeticToECEF(tt.lat, tt.lon, tt.alt)
+			points, ok := footprint(p, tt.spec)
+			if !ok || len(points) != coverageSegments {
+				t.Fatalf("footprint(%v, %+v) = %d points, %v, want %d points", p, tt.spec, len(points), ok, coverageSegments)
+			}
+
+			r := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])
+			for _, q := range points {
+				lat, lon, h := ecefToGeodetic(q)
+				if math.Abs(h) > 1e-3 {
+					t.Fatalf("footprint point %v is %v m from the ellipsoid", q, h)
+				}
+
+				// elevation of p seen from the footprint point, and the angle of the point from nadir at p
+				toP := normalize3([3]float64{p[0] - q[0], p[1] - q[1], p[2] - q[2]})
+				n := geodeticToECEF(lat, lon, 1)
+				g := geodeticToECEF(lat, lon, 0)
+				elevation := math.Asin(toP[0]*(n[0]-g[0])+toP[1]*(n[1]-g[1])+toP[2]*(n[2]-g[2])) * (180 / math.Pi)
+				nadir := math.Acos((toP[0]*p[0]+toP[1]*p[1]+toP[2]*p[2])/r) * (180 / math.Pi)
+
+				// the horizon is where a ray touches the ellipsoid, other elevations are as seen on the stretched
+				// sphere and differ from the ellipsoid by up to a tenth of a degree
+				tolerance := 1e-4
+				if tt.spec.minElevation > 0 {
+					tolerance = 0.1
+				}
+				switch {
+				case tt.spec.byElevation && math.Abs(elevation-tt.spec.minElevation) > tolerance:
+					t.Fatalf("footprint point %v is seen at %v degrees elevation, want %v", q, elevation, tt.spec.minElevation)
+				case !tt.spec.byElevation && tt.spec.halfAngle < 60 && math.Abs(nadir-tt.spec.halfAngle) > 1e-6:
+					t.Fatalf("footprint point %v is %v degrees from nadir, want %v", q, nadir, tt.spec.halfAngle)
+				case !tt.spec.byElevation && tt.spec.halfAngle >= 60 && math.Abs(elevation) > tolerance:
+					t.Fatalf("footprint point %v is seen at %v degrees elevation, want the horizon", q, elevation)
+				}
+			}
+		})
+	}
+}
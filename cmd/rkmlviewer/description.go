@@ -0,0 +1,193 @@
+package main
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/tview"
+)
+
+// htmlState tracks open formatting and list tags while converting a description
+type htmlState struct {
+	out    strings.Builder
+	bold   int
+	italic int
+	under  int
+	lists  []int // item counter of each open list, -1 for unordered lists
+	line   bool  // whether the output ends in the middle of a line
+	space  bool  // whether whitespace was skipped since the last word
+}
+
+// converts a KML HTML description into tview styled text. lists, line breaks, paragraphs,
+// headings, bold, italic and underline are kept, links are shown as their text, other tags are dropped
+func htmlToTview(description string) string {
+	st := &htmlState{}
+	s := description
+
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			st.text(s)
+			break
+		}
+		st.text(s[:i])
+		s = s[i:]
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			st.text(s)
+			break
+		}
+		st.tag(s[1:end])
+		s = s[end+1:]
+	}
+
+	return strings.Trim(st.out.String(), "\n")
+}
+
+// writes text with whitespace collapsed as a browser would
+func (st *htmlState) text(s string) {
+	s = html.UnescapeString(s)
+	if s == "" {
+		return
+	}
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		st.space = true
+	}
+	for i, word := range strings.Fields(s) {
+		if i > 0 {
+			st.space = true
+		}
+		if st.line && st.space {
+			st.out.WriteString(" ")
+		}
+		st.out.WriteString(tview.Escape(word))
+		st.line = true
+		st.space = false
+	}
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		st.space = true
+	}
+}
+
+// handles one tag, given without its angle brackets
+func (st *htmlState) tag(t string) {
+	t = strings.TrimSpace(strings.TrimSuffix(t, "/"))
+	closing := strings.HasPrefix(t, "/")
+	t = strings.TrimPrefix(t, "/")
+	fields := strings.Fields(t)
+	if len(fields) == 0 {
+		// empty tags like <> or </> are dropped
+		return
+	}
+	name := strings.ToLower(fields[0])
+
+	switch name {
+	case "b", "strong":
+		st.bold += step(closing)
+		st.style()
+	case "i", "em":
+		st.italic += step(closing)
+		st.style()
+	case "u":
+		st.under += step(closing)
+		st.style()
+	case "br":
+		st.newline(true)
+	case "p", "div", "tr":
+		st.newline(false)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		st.newline(false)
+		st.bold += step(closing)
+		st.style()
+	case "ul", "ol":
+		st.newline(false)
+		if closing {
+			if len(st.lists) > 0 {
+				st.lists = st.lists[:len(st.lists)-1]
+			}
+		} else if name == "ol" {
+			st.lists = append(st.lists, 0)
+		} else {
+			st.lists = append(st.lists, -1)
+		}
+	case "li":
+		st.newline(false)
+		if closing {
+			return
+		}
+		depth := len(st.lists)
+		marker := " • "
+		if depth > 0 && st.lists[depth-1] >= 0 {
+			st.lists[depth-1]++
+			marker = " " + strconv.Itoa(st.lists[depth-1]) + ". "
+		}
+		if depth > 1 {
+			st.out.WriteString(strings.Repeat("  ", depth-1))
+		}
+		st.out.WriteString(marker)
+		st.line = true
+		st.space = false
+	case "td", "th":
+		if !closing && st.line {
+			st.out.WriteString("  ")
+			st.space = false
+		}
+	}
+}
+
+// ends the current line. forced breaks (<br>) are written even on an empty line
+func (st *htmlState) newline(force bool) {
+	if st.line || force {
+		st.out.WriteString("\n")
+	}
+	st.line = false
+	st.space = false
+}
+
+// writes the tview attribute tag for the currently open formatting tags
+func (st *htmlState) style() {
+	if st.bold < 0 {
+		st.bold = 0
+	}
+	if st.italic < 0 {
+		st.italic = 0
+	}
+	if st.under < 0 {
+		st.under = 0
+	}
+
+	attrs := ""
+	if st.bold > 0 {
+		attrs += "b"
+	}
+	if st.italic > 0 {
+		attrs += "i"
+	}
+	if st.under > 0 {
+		attrs += "u"
+	}
+	if attrs == "" {
+		attrs = "-"
+	}
+	st.out.WriteString("[::" + attrs + "]")
+}
+
+// +1 for an opening tag, -1 for a closing tag
+func step(closing bool) int {
+	if closing {
+		return -1
+	}
+	return 1
+}
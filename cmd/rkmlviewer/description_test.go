@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestHTMLToTview(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        string
+	}{
+		{"plain text", "hello  world", "hello world"},
+		{"bold", "a <b>bold</b> word", "a[::b] bold[::-] word"},
+		{"line break", "one<br>two", "one\ntwo"},
+		{"list", "<ul><li>one</li><li>two</li></ul>", " • one\n • two"},
+		{"ordered list", "<ol><li>one</li><li>two</li></ol>", " 1. one\n 2. two"},
+		{"link", `see <a href="http://example.com">here</a>`, "see here"},
+		{"comment", "a<!-- hidden -->b", "ab"},
+		{"entities", "1 &lt; 2 &amp; 3", "1 < 2 & 3"},
+		{"empty tag", "a<>b", "ab"},
+		{"empty closing tag", "a</>b", "ab"},
+		{"empty self closing tag", "a< />b", "ab"},
+		{"blank tag", "a<   >b", "ab"},
+		{"unclosed tag", "a <b", "a <b"},
+		{"unclosed comment", "a<!-- b", "a"},
+		{"stray closing bracket", "a > b", "a > b"},
+		{"unbalanced closing tags", "</b></i></ul>text", "[::-][::-]text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlToTview(tt.description); got != tt.want {
+				t.Errorf("htmlToTview(%q) = %q, want %q", tt.description, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// reference frames a feature's coordinates can be given in, set with an ExtendedData "frame" value
+	frameECEF = "ECEF" // earth centered, earth fixed: turns with the globe
+	frameECI  = "ECI"  // earth centered inertial: fixed while the globe turns under it
+)
+
+var (
+	// simulation time, advanced with the frame time
+	simTime = time.Now().UTC()
+
+	// positions of the kml and icon vertices as built, with inertial features in the ECI frame.
+	// inertial features are turned into the earth fixed frame from these every time the earth rotates
+	inertialVertices []float32
+	inertialIcons    []float32
+
+	// earth rotation the inertial features were last turned by, NaN to force an update
+	inertialAngle = math.NaN()
+)
+
+// returns the Greenwich Mean Sidereal Time in degrees (0-360) for a UTC time (IAU 1982 model)
+func gmst(t time.Time) float64 {
+	jd := float64(t.UnixNano())/(86400*1e9) + 2440587.5
+	d := jd - 2451545.0 // days since J2000
+	c := d / 36525      // julian centuries since J2000
+
+	theta := 280.46061837 + 360.98564736629*d + 0.000387933*c*c - c*c*c/38710000
+	theta = math.Mod(theta, 360)
+	if theta < 0 {
+		theta += 360
+	}
+	return theta
+}
+
+// returns the angle in degrees the globe is turned by about its axis: GMST of the
+// simulation time when sidereal rotation is on, else 0
+func earthRotation() float64 {
+	if !state.siderealRotation {
+		return 0
+	}
+	return gmst(simTime)
+}
+
+// advances the simulation time by dt seconds
+func advanceSimTime(dt float64) {
+	simTime = simTime.Add(time.Duration(dt * float64(time.Second)))
+}
+
+// turns an ECI vector into the ECEF frame at a UTC time
+func eciToECEF(v mgl32.Vec3, t time.Time) mgl32.Vec3 {
+	sin, cos := math.Sincos(-gmst(t) * (math.Pi / 180))
+	return mgl32.Vec3{
+		float32(float64(v[0])*cos - float64(v[1])*sin),
+		float32(float64(v[0])*sin + float64(v[1])*cos),
+		v[2],
+	}
+}
+
+// returns the value of an ExtendedData <Data> element of a feature
+func extendedData(f Folder, name string) (string, bool) {
+	for _, d := range f.ExtendedData.Data {
+		if d.Name == name {
+			return strings.TrimSpace(d.Value), true
+		}
+	}
+	return "", false
+}
+
+// returns the frame of the feature at path. the frame is inherited from the closest
+// enclosing folder that sets one, and is ECEF if none does
+func featureFrame(path []string) string {
+	frame := frameECEF
+	if len(kml.Folders) == 0 {
+		return frame
+	}
+
+	f := kml.Folders[0]
+	frame = folderFrame(f, frame)
+	for _, name := range path {
+		i, ok := m[name]
+		if !ok || i >= len(f.Folders) {
+			break
+		}
+		f = f.Folders[i]
+		frame = folderFrame(f, frame)
+	}
+	return frame
+}
+
+// returns the frame a folder sets, or the inherited frame if it sets none
+func folderFrame(f Folder, inherited string) string {
+	if v, ok := extendedData(f, "frame"); ok {
+		switch strings.ToUpper(v) {
+		case frameECI:
+			return frameECI
+		case frameECEF:
+			return frameECEF
+		}
+	}
+	return inherited
+}
+
+// keeps the inertial features in place while the earth rotates, by turning their positions from
+// inertialVertices and inertialIcons back by the earth rotation. vertices are the kml vertices,
+// icons the icon vertices. returns true if any position changed
+func updateInertial(vertices []float32, icons []float32) bool {
+	angle := earthRotation()
+	if angle == inertialAngle {
+		return false
+	}
+	inertialAngle = angle
+
+	sin, cos := math.Sincos(-angle * (math.Pi / 180))
+	rotate := func(dst []float32, src []float32, i int) {
+		x, y := float64(src[i]), float64(src[i+1])
+		dst[i] = float32(x*cos - y*sin)
+		dst[i+1] = float32(x*sin + y*cos)
+	}
+
+	changed := false
+	for _, f := range pickFeatures {
+		if !f.inertial {
+			continue
+		}
+		ranges := []vertexRange{f.lines, f.points, f.orbits}
+		if !f.groundFixed {
+			ranges = append(ranges, f.groundTrack)
+		}
+		for _, r := range ranges {
+			for i := r.first; i < r.first+r.count; i++ {
+				rotate(vertices, inertialVertices, int(i)*6)
+				changed = true
+			}
+		}
+		for i := f.icons.first; i < f.icons.first+f.icons.count; i++ {
+			rotate(icons, inertialIcons, int(i)*iconStride)
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// map lines are drawn this far above the ground (earth radii) so the earth model doesn't cover them
+	mapLineLift = 0.001
+
+	// longest piece of a map line in degrees of latitude or longitude, short enough to follow the earth
+	mapLineStep = 0.5
+)
+
+var (
+	// graticule spacings in degrees offered in the gui
+	graticuleSpacings = []float64{1, 2, 5, 10, 15, 30}
+
+	// colors of the map lines
+	graticuleColor = mgl32.Vec3{0.7, 0.7, 0.7}
+	coastlineColor = mgl32.Vec3{0.55, 0.8, 1.0}
+	borderColor    = mgl32.Vec3{1.0, 0.85, 0.4}
+
+	// coastlines and borders read from the bundled data, as lists of lon/lat positions
+	coastlines [][][]float64
+	borders    [][][]float64
+
+	// the detailed boundary mask, loaded the first time it is shown since it is large
+	boundaryTexture       uint32
+	boundaryTextureFailed bool
+)
+
+// mapLines is the graticule, the coastlines and the borders in one vertex buffer, in the line vertex layout
+type mapLines struct {
+	vertexArray  uint32
+	vertexBuffer uint32
+
+	// graticule spacing the buffer was built with
+	spacing float64
+
+	graticule  vertexRange
+	coastlines vertexRange
+	borders    vertexRange
+}
+
+// geoJSON is any GeoJSON object, only the members needed to find lines are read
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Features    []geoJSON       `json:"features"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// reads the lines of a GeoJSON asset. line strings are read as they are, polygons as their rings
+func loadGeoJSONLines(name string) ([][][]float64, error) {
+	file, err := openAsset(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	var g geoJSON
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	lines, err := g.lines()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return lines, nil
+}
+
+// returns the lines of a GeoJSON object and the objects it contains
+func (g *geoJSON) lines() ([][][]float64, error) {
+	lines := [][][]float64{}
+	var err error
+	switch g.Type {
+	case "FeatureCollection":
+		for i := range g.Features {
+			l, err := g.Features[i].lines()
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, l...)
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return g.Geometry.lines()
+		}
+	case "GeometryCollection":
+		for i := range g.Geometries {
+			l, err := g.Geometries[i].lines()
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, l...)
+		}
+	case "LineString":
+		var line [][]float64
+		err = json.Unmarshal(g.Coordinates, &line)
+		lines = append(lines, line)
+	case "MultiLineString", "Polygon":
+		err = json.Unmarshal(g.Coordinates, &lines)
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		err = json.Unmarshal(g.Coordinates, &polygons)
+		for _, p := range polygons {
+			lines = append(lines, p...)
+		}
+	}
+	return lines, err
+}
+
+// reads the bundled coastlines and borders, a missing file leaves its lines empty
+func loadMapLines() {
+	var err error
+	if coastlines, err = loadGeoJSONLines(coastlinesPath); err != nil {
+		addWarning(fmt.Sprint("Coastlines disabled: ", err))
+	}
+	if borders, err = loadGeoJSONLines(bordersPath); err != nil {
+		addWarning(fmt.Sprint("Borders disabled: ", err))
+	}
+}
+
+// binds the boundary mask to texture unit 7, loading it first if needed. returns false if it can't be loaded
+func bindBoundaryTexture() bool {
+	if boundaryTexture == 0 && !boundaryTextureFailed {
+		// loading takes a moment
+		report("Boundary texture", "Loading "+boundaryTexturePath)
+		pixels, x, y, err := loadGrayImage(boundaryTexturePath)
+		var maxSize int32
+		gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &maxSize)
+		if err == nil && (x > maxSize || y > maxSize) {
+			err = fmt.Errorf("%dx%d is larger than the graphics driver supports (%d)", x, y, maxSize)
+		}
+		if err != nil {
+			addWarning(fmt.Sprint("Boundary texture disabled: ", err))
+			boundaryTextureFailed = true
+			return false
+		}
+		boundaryTexture = textureFromPixels(pixels, x, y, dataGray)
+	}
+	if boundaryTexture == 0 {
+		return false
+	}
+	gl.ActiveTexture(gl.TEXTURE7)
+	gl.BindTexture(gl.TEXTURE_2D, boundaryTexture)
+	return true
+}
+
+// returns the model space position of a map line point, lifted above the ground
+func mapLineVertex(lat float64, lon float64) mgl32.Vec3 {
+	x, y, z := latLonToVertex(lat, lon, math.Max(0, groundHeight(lat, lon)))
+	return mgl32.Vec3{x, y, z}.Mul(float32(radius + mapLineLift))
+}
+
+// appends a line through lat/lon points as segments (two vertices each) in the line vertex layout.
+// long segments are split so they follow the parallels and meridians instead of cutting through the earth
+func appendMapLine(vertices []float32, points [][2]float64, color mgl32.Vec3) []float32 {
+	for i := 0; i+1 < len(points); i++ {
+		lat1, lon1 := points[i][0], points[i][1]
+		lat2, lon2 := points[i+1][0], points[i+1][1]
+		n := int(math.Max(1, math.Ceil(math.Max(math.Abs(lat2-lat1), math.Abs(lon2-lon1))/mapLineStep)))
+
+		prev := mapLineVertex(lat1, lon1)
+		for j := 1; j <= n; j++ {
+			f := float64(j) / float64(n)
+			p := mapLineVertex(lat1+(lat2-lat1)*f, lon1+(lon2-lon1)*f)
+			vertices = append(vertices,
+				prev[0], prev[1], prev[2], color[0], color[1], color[2],
+				p[0], p[1], p[2], color[0], color[1], color[2])
+			prev = p
+		}
+	}
+	return vertices
+}
+
+// returns the graticule line positions for a spacing: 0, then both signs of each multiple of spacing below limit.
+// the limit itself is included if it is a multiple of spacing and include is set
+func graticuleValues(spacing float64, limit float64, include bool) []float64 {
+	values := []float64{0}
+	for v := spacing; v < limit; v += spacing {
+		values = append(values, v, -v)
+	}
+	if include && math.Mod(limit, spacing) == 0 {
+		values = append(values, limit)
+	}
+	return values
+}
+
+// returns the vertices of the parallels and meridians every spacing degrees
+func graticuleVertices(spacing float64) []float32 {
+	vertices := []float32{}
+	for _, lat := range graticuleValues(spacing, 90, false) {
+		vertices = appendMapLine(vertices, [][2]float64{{lat, -180}, {lat, 180}}, graticuleColor)
+	}
+	for _, lon := range graticuleValues(spacing, 180, true) {
+		vertices = appendMapLine(vertices, [][2]float64{{90, lon}, {-90, lon}}, graticuleColor)
+	}
+	return vertices
+}
+
+// appends the vertices of GeoJSON lines (lon/lat positions) in one color
+func appendGeoJSONLines(vertices []float32, lines [][][]float64, color mgl32.Vec3) []float32 {
+	for _, line := range lines {
+		points := make([][2]float64, 0, len(line))
+		for _, p := range line {
+			if len(p) >= 2 {
+				points = append(points, [2]float64{p[1], p[0]})
+			}
+		}
+		vertices = appendMapLine(vertices, points, color)
+	}
+	return vertices
+}
+
+// (re)builds the map line vertex buffer for a graticule spacing
+func (m *mapLines) build(spacing float64) {
+	vertices := graticuleVertices(spacing)
+	m.graticule = vertexRange{0, int32(len(vertices) / 6)}
+	vertices = appendGeoJSONLines(vertices, coastlines, coastlineColor)
+	m.coastlines = vertexRange{m.graticule.count, int32(len(vertices)/6) - m.graticule.count}
+	vertices = appendGeoJSONLines(vertices, borders, borderColor)
+	m.borders = vertexRange{m.coastlines.first + m.coastlines.count, int32(len(vertices)/6) - m.coastlines.first - m.coastlines.count}
+	m.spacing = spacing
+
+	if m.vertexArray == 0 {
+		m.vertexArray, m.vertexBuffer = makeVaoColoredLines(vertices, nil, 6*4)
+		return
+	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, m.vertexBuffer)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.DYNAMIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+}
+
+// draws the map lines turned on in the gui with the object program, which must be in use.
+// the buffer is rebuilt first if the graticule spacing changed
+func (m *mapLines) draw(program *shaderProgram) {
+	if state.graticuleSpacing != m.spacing {
+		m.build(state.graticuleSpacing)
+	}
+
+	program.set("highlight", false)
+	gl.BindVertexArray(m.vertexArray)
+	for _, r := range []struct {
+		show bool
+		vertexRange
+	}{
+		{state.showGraticule, m.graticule},
+		{state.showCoastlines, m.coastlines},
+		{state.showBorders, m.borders},
+	} {
+		if r.show && r.count > 0 {
+			gl.DrawArrays(gl.LINES, r.first, r.count)
+		}
+	}
+}
+
+// returns the text of a graticule label, e.g. 30N for latitude 30 with suffixes N and S
+func graticuleText(v float64, positive string, negative string) string {
+	text := strconv.FormatFloat(math.Abs(v), 'f', -1, 64)
+	switch {
+	case v == 0 || v == 180:
+		return text
+	case v > 0:
+		return text + positive
+	}
+	return text + negative
+}
+
+// returns the graticule labels near the camera: the parallels are labelled along the meridian
+// closest to the point below the camera and the meridians along the closest parallel
+func graticuleLabels(mvp mgl32.Mat4, model mgl32.Mat4, eye mgl32.Vec3) []label {
+	spacing := state.graticuleSpacing
+	local := model.Inv().Mul4x1(eye.Vec4(1)).Vec3()
+	camLat := math.Atan2(float64(local[2]), math.Hypot(float64(local[0]), float64(local[1]))) * (180 / math.Pi)
+	camLon := math.Atan2(float64(local[1]), float64(local[0])) * (180 / math.Pi)
+
+	lat0 := math.Round(camLat/spacing) * spacing
+	lat0 = math.Max(-90+spacing, math.Min(90-spacing, lat0))
+	lon0 := math.Round(camLon/spacing) * spacing
+	if lon0 <= -180 {
+		lon0 += 360
+	}
+
+	labels := []label{}
+	for _, lat := range graticuleValues(spacing, 90, false) {
+		if l, ok := newLabel(graticuleText(lat, "N", "S"), mapLineVertex(lat, lon0), mvp, model, eye); ok {
+			labels = append(labels, l)
+		}
+	}
+	for _, lon := range graticuleValues(spacing, 180, true) {
+		if l, ok := newLabel(graticuleText(lon, "E", "W"), mapLineVertex(lat0, lon), mvp, model, eye); ok {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// half length of a time tick across a ground track, in earth radii
+	groundTickLength = 0.006
+
+	// most ticks drawn on one ground track, the interval is doubled until they fit
+	groundTickMax = 500
+)
+
+var (
+	// time between the ticks on ground tracks, set with -tickinterval
+	groundTickInterval = 5 * time.Minute
+
+	// time ticks of the drawn ground tracks, rebuilt by interpretSelected
+	groundTicks []groundTick
+)
+
+// groundTick is a time tick on a ground track and the kml vertex its label is attached to
+type groundTick struct {
+	text   string
+	vertex int32
+}
+
+// returns the ground track of a feature's track: the points below its coordinates (the ones appendVert
+// draws at altitude) joined just above the ground, and time ticks across it if every coordinate has a
+// valid <when>. frame is the frame of the coordinates: the ground track of a timed inertial track is
+// built in the earth fixed frame, each point below the position at its time, and fixed is true as it
+// must not be turned with the inertial features. the tick vertex indexes are relative to the returned vertices
+func groundTrackVertices(f Folder, frame string) (vertices []float32, ticks []groundTick, fixed bool) {
+	track := []Coordinate{}
+	for _, c := range f.Track.Coords {
+		track = append(track, parseCoordinates(c)...)
+	}
+	if len(track) < 2 {
+		return nil, nil, false
+	}
+	r, g, b := getColor(f.StyleURL)
+	color := mgl32.Vec3{r, g, b}
+
+	// times of the coordinates, nil unless every one is valid and they don't go back
+	whens := make([]time.Time, len(track))
+	if len(f.Track.Whens) != len(track) {
+		whens = nil
+	}
+	for i := range whens {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(f.Track.Whens[i]))
+		if err != nil || (i > 0 && t.Before(whens[i-1])) {
+			whens = nil
+			break
+		}
+		whens[i] = t.UTC()
+	}
+	fixed = frame == frameECI && whens != nil
+
+	// longitudes are unwrapped so segments across the antimeridian take the short way
+	points := make([][2]float64, len(track))
+	for i, c := range track {
+		lon := c.Lon + frameLonOffset
+		if fixed {
+			lon -= gmst(whens[i])
+		}
+		if i > 0 {
+			prev := points[i-1][1]
+			lon = prev + math.Mod(math.Mod(lon-prev+180, 360)+360, 360) - 180
+		}
+		points[i] = [2]float64{c.Lat, lon}
+	}
+	vertices = appendMapLine([]float32{}, points, color)
+
+	if whens == nil {
+		return vertices, nil, fixed
+	}
+
+	interval := groundTickInterval
+	if interval <= 0 {
+		return vertices, nil, fixed
+	}
+	for whens[len(whens)-1].Sub(whens[0])/interval > groundTickMax {
+		interval *= 2
+	}
+	layout := "15:04"
+	if interval%time.Minute != 0 {
+		layout = "15:04:05"
+	}
+
+	ticks = []groundTick{}
+	i := 0
+	for t := whens[0].Truncate(interval); !t.After(whens[len(whens)-1]); t = t.Add(interval) {
+		if t.Before(whens[0]) {
+			continue
+		}
+		for i+2 < len(whens) && whens[i+1].Before(t) {
+			i++
+		}
+		frac := 0.0
+		if d := whens[i+1].Sub(whens[i]); d > 0 {
+			frac = float64(t.Sub(whens[i])) / float64(d)
+		}
+		lat := points[i][0] + (points[i+1][0]-points[i][0])*frac
+		lon := points[i][1] + (points[i+1][1]-points[i][1])*frac
+
+		along := mapLineVertex(points[i+1][0], points[i+1][1]).Sub(mapLineVertex(points[i][0], points[i][1]))
+		center := mapLineVertex(lat, lon)
+		across := along.Cross(center)
+		if across.Len() == 0 {
+			continue
+		}
+		across = across.Normalize().Mul(groundTickLength)
+
+		ticks = append(ticks, groundTick{text: t.Format(layout), vertex: int32(len(vertices) / 6)})
+		p1, p2 := center.Add(across), center.Sub(across)
+		vertices = append(vertices,
+			p1[0], p1[1], p1[2], color[0], color[1], color[2],
+			p2[0], p2[1], p2[2], color[0], color[1], color[2])
+	}
+	return vertices, ticks, fixed
+}
+
+// returns the labels of the ground track ticks. vertices are the kml vertices
+func groundTickLabels(vertices []float32, mvp mgl32.Mat4, model mgl32.Mat4, eye mgl32.Vec3) []label {
+	labels := []label{}
+	for _, t := range groundTicks {
+		if l, ok := newLabel(t.text, vertexPos(vertices, t.vertex), mvp, model, eye); ok {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,11 +20,29 @@ var mutex = &sync.Mutex{}
 var root *tview.TreeNode
 var app *tview.Application
 var m = make(map[string]int)
+
+// tree nodes of features with a kml id, used by tour updates
+var featureNodes = make(map[string]*tview.TreeNode)
+
+// lists the saved camera bookmarks
+var bookmarkView *tview.List
+
+// shows the name and description of the focused node or the feature under the mouse
+var detailsView *tview.TextView
 var kml Folder
 
+// shows the warnings collected by addWarning in the options column, nil until the gui is built
+var warningView *tview.TextView
+var optionsView *tview.Flex
+
+// warnings about missing or broken assets, shown in the gui
+var warnings struct {
+	sync.Mutex
+	list []string
+}
+
 // Show a navigable tree view of the current directory.
 func gui(win *glfw.Window) {
-	kml = readKML(visualOutputPath, 0)
 	rootDir := "Document"
 	var rootSlice = []string{"true"}
 	root = tview.NewTreeNode(rootDir).SetReference(rootSlice).SetSelectable(true)
@@ -40,10 +59,53 @@ func gui(win *glfw.Window) {
 		AddCheckbox("Show Orbits", state.showOrbits, showOrbitsCallback).
 		AddCheckbox("Show Points", state.showPoints, showPointsCallback).
 		AddCheckbox("Show LOS/Blocked/Basis Lines", state.showLines, showLinesCallback).
+		AddCheckbox("Show Labels", state.showLabels, showLabelsCallback).
+		AddCheckbox("Show Overlays", state.showOverlays, showOverlaysCallback).
+		AddCheckbox("Sidereal Earth Rotation", state.siderealRotation, siderealRotationCallback).
+		AddCheckbox("Show Terminator", state.showTerminator, showTerminatorCallback).
+		AddCheckbox("Show Stars, Sun and Moon", state.showSky, showSkyCallback).
+		AddCheckbox("Show Graticule", state.showGraticule, showGraticuleCallback).
+		AddDropDown("Graticule Spacing", graticuleSpacingOptions(), graticuleSpacingIndex(), graticuleSpacingCallback).
+		AddCheckbox("Show Coastlines", state.showCoastlines, showCoastlinesCallback).
+		AddCheckbox("Show Borders", state.showBorders, showBordersCallback).
+		AddCheckbox("Show Detailed Borders (Texture, Loads Slowly)", state.showBoundaryMask, showBoundaryMaskCallback).
+		AddCheckbox("Show Coverage Cones", state.showCoverage, showCoverageCallback).
+		AddCheckbox("Show Ground Tracks", state.showGroundTracks, showGroundTracksCallback).
+		AddCheckbox("Measurement Mode (Click in Window)", state.measuring, measuringCallback).
+		AddDropDown("New Cone Min Elevation", coverageElevationOptions(), coverageElevationIndex(), coverageElevationCallback).
 		AddCheckbox("Enable Antialiasing (MSAA) (Performance Impact: HIGH)", state.enableAntialiasing, enableAntialiasingCallback).
-		AddCheckbox("Enable OpenGL Blending (Performance Impact: MEDIUM)", state.enableBlending, enableBlendingCallback)
+		AddCheckbox("Enable OpenGL Blending (Performance Impact: MEDIUM)", state.enableBlending, enableBlendingCallback).
+		AddButton("Clear Lines of Sight", clearLOSResults).
+		AddButton("Clear Measurements", clearMeasurements)
+	if names := tourNames(); len(names) > 0 {
+		optionForm.AddDropDown("Tour", names, 0, func(option string, index int) { selectTour(index) }).
+			AddButton("Play/Pause Tour", toggleTour).
+			AddButton("Stop Tour", stopTour)
+	}
+	bookmarkView = tview.NewList().ShowSecondaryText(false).
+		SetMainTextColor(tcell.ColorWhite).
+		SetSelectedBackgroundColor(tcell.NewRGBColor(48, 70, 192))
+	bookmarkView.SetBorder(true).SetTitle("Bookmarks").SetBorderColor(tcell.NewRGBColor(48, 70, 192))
+	fillBookmarkView()
+
+	detailsView = tview.NewTextView().SetWordWrap(true).SetDynamicColors(true).SetScrollable(true)
+	detailsView.SetBorder(true).SetTitle("Details").SetBorderColor(tcell.NewRGBColor(48, 70, 192))
+
 	options := tview.NewFlex().
-		AddItem(optionForm, 0, 1, true)
+		AddItem(optionForm, 0, 1, true).
+		AddItem(bookmarkView, 12, 1, false).
+		AddItem(detailsView, 0, 1, false)
+
+	// warnings take no space until there are some
+	warnings.Lock()
+	warningView = tview.NewTextView().SetWordWrap(true).SetScrollable(true).SetTextColor(tcell.ColorYellow)
+	warningView.SetBorder(true).SetTitle("Warnings").SetBorderColor(tcell.ColorRed)
+	for _, w := range warnings.list {
+		fmt.Fprintln(warningView, w)
+	}
+	options.AddItem(warningView, warningViewHeight(len(warnings.list)), 0, false)
+	optionsView = options
+	warnings.Unlock()
 
 	options.SetDirection(tview.FlexRow).
 		SetBorder(true).
@@ -57,6 +119,7 @@ func gui(win *glfw.Window) {
 	word := " [black:#BF308D]             IN TERMINAL              [white] \n" +
 		" Select KML.................[#000000:#3046c0]     1     [white] \n" +
 		" Select Render Attributes...[#000000:#3046c0]     2     [white] \n" +
+		" Select Bookmarks...........[#000000:#3046c0]     3     [white] \n" +
 		" Move Up Tree...............[#000000:#3046c0]  ArrowUp  [white] \n" +
 		" Move Down Tree.............[#000000:#3046c0] ArrowDown [white] \n" +
 		" Move Up Tree By Page.......[#000000:#3046c0]  Page_Up  [white] \n" +
@@ -64,6 +127,10 @@ func gui(win *glfw.Window) {
 		" Select/Deselect............[#000000:#3046c0]   Space   [white] \n" +
 		" Reload KML.................[#000000:#3046c0]     X     [white] \n" +
 		" Collapse Node..............[#000000:#3046c0]     Z     [white] \n" +
+		" Fly To Node View...........[#000000:#3046c0]     F     [white] \n" +
+		" Play/Pause Tour............[#000000:#3046c0]     P     [white] \n" +
+		" Line Of Sight From/To......[#000000:#3046c0]     L     [white] \n" +
+		" Coverage Cone On/Off.......[#000000:#3046c0]     V     [white] \n" +
 		" Show/Hide Controls.........[#000000:#3046c0]     C     [white] \n" +
 		" [black:#BF308D]             IN WINDOW                [white] \n" +
 		" Move Forward...............[#000000:#3046c0]     W     [white] \n" +
@@ -73,6 +140,18 @@ func gui(win *glfw.Window) {
 		" Move Up....................[#000000:#3046c0]   Space   [white] \n" +
 		" Move Down..................[#000000:#3046c0]   Shift   [white] \n" +
 		" Increase/Decrease Speed....[#000000:#3046c0]   Scroll  [white] \n" +
+		" Show/Hide Graticule........[#000000:#3046c0]     G     [white] \n" +
+		" Show/Hide Coastlines.......[#000000:#3046c0]     K     [white] \n" +
+		" Show/Hide Borders..........[#000000:#3046c0]     B     [white] \n" +
+		" Show/Hide Coverage Cones...[#000000:#3046c0]     V     [white] \n" +
+		" Show/Hide Ground Tracks....[#000000:#3046c0]     T     [white] \n" +
+		" Measurement Mode On/Off....[#000000:#3046c0]     M     [white] \n" +
+		" Measure (Mode On, Unlocked)[#000000:#3046c0] MouseLeft [white] \n" +
+		" Show/Hide Sky..............[#000000:#3046c0]     9     [white] \n" +
+		" Show/Hide Terminator.......[#000000:#3046c0]     8     [white] \n" +
+		" Sidereal Rotation On/Off...[#000000:#3046c0]     7     [white] \n" +
+		" Show/Hide Overlays.........[#000000:#3046c0]     6     [white] \n" +
+		" Show/Hide Labels...........[#000000:#3046c0]     5     [white] \n" +
 		" Show/Hide Orbits...........[#000000:#3046c0]     4     [white] \n" +
 		" Show/Hide Points...........[#000000:#3046c0]     3     [white] \n" +
 		" Show/Hide Lines............[#000000:#3046c0]     2     [white] \n" +
@@ -80,6 +159,8 @@ func gui(win *glfw.Window) {
 		" Rotate Earth Left..........[#000000:#3046c0] ArrowLeft [white] \n" +
 		" Rotate Earth Right.........[#000000:#3046c0] ArrowRight[white] \n" +
 		" Toggle Mouse Lock..........[#000000:#3046c0] MouseLeft [white] \n" +
+		" Save Bookmark..............[#000000:#3046c0] Ctrl+0-9  [white] \n" +
+		" Go To Bookmark.............[#000000:#3046c0]  Alt+0-9  [white] \n" +
 		" Quit.......................[#000000:#3046c0]     Q     [white]"
 
 	fmt.Fprintf(controlBox, "%s ", word)
@@ -98,6 +179,7 @@ func gui(win *glfw.Window) {
 
 	// fmt.Println(kml.Folders)
 	tree.SetSelectedFunc(onSelect)
+	tree.SetChangedFunc(showNodeDetails)
 	tree.SetBorder(true)
 	tree.SetBorderColor(tcell.NewRGBColor(48, 70, 192))
 	tree.SetTitle("KML Explorer")
@@ -123,6 +205,8 @@ func gui(win *glfw.Window) {
 				//log.Println("!")
 			case '2':
 				app.SetFocus(optionForm)
+			case '3':
+				app.SetFocus(bookmarkView)
 			case ' ':
 				k := tcell.KeyEnter
 				return tcell.NewEventKey(k, 0, tcell.ModNone)
@@ -135,6 +219,20 @@ func gui(win *glfw.Window) {
 			case 'z':
 				n := tree.GetCurrentNode()
 				n.SetExpanded(!n.IsExpanded())
+			case 'f':
+				flyToNode(tree.GetCurrentNode())
+			case 'p':
+				toggleTour()
+			case 'v':
+				if n := tree.GetCurrentNode(); n != nil {
+					ref := n.GetReference().([]string)
+					toggleCone(ref[:len(ref)-1])
+				}
+			case 'l':
+				if n := tree.GetCurrentNode(); n != nil {
+					ref := n.GetReference().([]string)
+					go markLOSEnd(ref[:len(ref)-1])
+				}
 			case 'c':
 				if showControls {
 					flex.RemoveItem(controls)
@@ -177,6 +275,9 @@ func scanFolder(node *tview.TreeNode, folder Folder, prefix string, previous Fol
 	prefix += "$" + previous.Name
 	currentNode := add(node, folder.Name, prefix+"$"+folder.Name, folder)
 	m[folder.Name] = index
+	if folder.FeatureID != "" {
+		featureNodes[folder.FeatureID] = currentNode
+	}
 	for i := range folder.Folders {
 		//index++
 		scanFolder(currentNode, folder.Folders[i], prefix, folder, i)
@@ -222,6 +323,98 @@ func setColor(node *tview.TreeNode, color tcell.Color, ref string) {
 	}
 }
 
+// flies the camera to the <LookAt> or <Camera> of the feature at node, or of its closest ancestor with one.
+// the flight is queued for the render loop
+func flyToNode(node *tview.TreeNode) {
+	if node == nil {
+		return
+	}
+	ref := node.GetReference().([]string)
+	path := ref[:len(ref)-1]
+
+	for i := len(path); i > 0; i-- {
+		if f, ok := lookupFolder(path[:i]); ok {
+			if pose, ok := featureView(f); ok {
+				queueFlyTo(pose)
+				return
+			}
+		}
+	}
+
+	if len(kml.Folders) > 0 {
+		if pose, ok := featureView(kml.Folders[0]); ok {
+			queueFlyTo(pose)
+		}
+	}
+}
+
+// shows or hides the feature with the given kml id by selecting or deselecting its tree node
+func setFeatureVisibility(id string, visible bool) {
+	node, ok := featureNodes[id]
+	if !ok || app == nil {
+		return
+	}
+	app.QueueUpdateDraw(func() {
+		if visible {
+			setColor(node, tcell.NewRGBColor(48, 70, 192), "f")
+		} else {
+			setColor(node, tcell.ColorWhite, "t")
+		}
+		reloadKML()
+	})
+}
+
+// fills the bookmark list from the saved bookmarks
+func fillBookmarkView() {
+	bookmarkView.Clear()
+	keys, descriptions := bookmarkList()
+	for i := range keys {
+		n := keys[i]
+		bookmarkView.AddItem(fmt.Sprintf("%d: %s", n, descriptions[i]), "", 0, func() { queueBookmark(n) })
+	}
+}
+
+// redraws the bookmark list after a bookmark is saved from the window
+func refreshBookmarks() {
+	if app == nil {
+		return
+	}
+	app.QueueUpdateDraw(fillBookmarkView)
+}
+
+// returns the details pane text for a feature name and HTML description
+func detailsText(name string, description string) string {
+	return "[#BF308D::b]" + tview.Escape(name) + "[white::-]\n\n" + htmlToTview(description)
+}
+
+// shows the focused tree node's name and description in the details pane
+func showNodeDetails(node *tview.TreeNode) {
+	if node == nil {
+		return
+	}
+	ref := node.GetReference().([]string)
+	f, ok := lookupFolder(ref[:len(ref)-1])
+	if !ok {
+		detailsView.SetText("")
+		return
+	}
+	detailsView.SetText(detailsText(f.Name, f.Description)).ScrollToBeginning()
+}
+
+// shows the picked feature's name and description, or clears the pane when f is nil
+func showPicked(f *feature) {
+	if app == nil {
+		return
+	}
+	text := ""
+	if f != nil {
+		text = detailsText(f.Name, f.Description)
+	}
+	app.QueueUpdateDraw(func() {
+		detailsView.SetText(text).ScrollToBeginning()
+	})
+}
+
 func showEarthCallback(x bool) {
 	state.showEarth = x
 }
@@ -238,6 +431,134 @@ func showPointsCallback(x bool) {
 	state.showPoints = x
 }
 
+// reports a problem that doesn't stop the viewer, in the gui once it runs and on stdout before
+func addWarning(msg string) {
+	if app == nil {
+		report("Warning", msg)
+	}
+
+	warnings.Lock()
+	warnings.list = append(warnings.list, msg)
+	view, count := warningView, len(warnings.list)
+	warnings.Unlock()
+
+	if view != nil && app != nil {
+		app.QueueUpdateDraw(func() {
+			fmt.Fprintln(view, msg)
+			optionsView.ResizeItem(view, warningViewHeight(count), 0)
+			view.ScrollToEnd()
+		})
+	}
+}
+
+// returns the height of the warning view for a number of warnings: hidden for none, at most 8 rows
+func warningViewHeight(count int) int {
+	if count == 0 {
+		return 0
+	}
+	if count > 6 {
+		count = 6
+	}
+	return count + 2
+}
+
+func showLabelsCallback(x bool) {
+	state.showLabels = x
+}
+
+func showOverlaysCallback(x bool) {
+	state.showOverlays = x
+}
+
+func siderealRotationCallback(x bool) {
+	state.siderealRotation = x
+}
+
+func showTerminatorCallback(x bool) {
+	state.showTerminator = x
+}
+
+func showSkyCallback(x bool) {
+	state.showSky = x
+}
+
+func showGraticuleCallback(x bool) {
+	state.showGraticule = x
+}
+
+// returns the graticule spacings offered in the gui, with the one given with -gridspacing
+func graticuleSpacingOptions() []string {
+	if graticuleSpacingIndex() == len(graticuleSpacings) {
+		graticuleSpacings = append(graticuleSpacings, state.graticuleSpacing)
+	}
+	options := []string{}
+	for _, s := range graticuleSpacings {
+		options = append(options, strconv.FormatFloat(s, 'f', -1, 64)+" deg")
+	}
+	return options
+}
+
+// returns the index of the current graticule spacing in graticuleSpacings, its length if it isn't there
+func graticuleSpacingIndex() int {
+	for i, s := range graticuleSpacings {
+		if s == state.graticuleSpacing {
+			return i
+		}
+	}
+	return len(graticuleSpacings)
+}
+
+func graticuleSpacingCallback(option string, index int) {
+	state.graticuleSpacing = graticuleSpacings[index]
+}
+
+func showCoastlinesCallback(x bool) {
+	state.showCoastlines = x
+}
+
+func showBordersCallback(x bool) {
+	state.showBorders = x
+}
+
+func showBoundaryMaskCallback(x bool) {
+	state.showBoundaryMask = x
+}
+
+func showCoverageCallback(x bool) {
+	state.showCoverage = x
+}
+
+func showGroundTracksCallback(x bool) {
+	state.showGroundTracks = x
+}
+
+func measuringCallback(x bool) {
+	state.measuring = x
+}
+
+// returns the minimum elevations offered in the gui for new coverage cones
+func coverageElevationOptions() []string {
+	options := []string{}
+	for _, e := range coverageElevations {
+		options = append(options, strconv.FormatFloat(e, 'f', -1, 64)+" deg")
+	}
+	return options
+}
+
+// returns the index of the current minimum elevation for new cones in coverageElevations, 0 if it isn't there
+func coverageElevationIndex() int {
+	for i, e := range coverageElevations {
+		if e == state.coverageElevation {
+			return i
+		}
+	}
+	return 0
+}
+
+func coverageElevationCallback(option string, index int) {
+	state.coverageElevation = coverageElevations[index]
+}
+
 func reloadKML() {
 	mutex.Lock()
 	selected = [][]string{{}}
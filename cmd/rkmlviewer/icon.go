@@ -0,0 +1,212 @@
+package main
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// size in pixels of one icon in the atlas
+	iconCellSize = 64
+
+	// size in pixels of an icon drawn with IconStyle scale 1
+	iconBaseSize = 32.0
+
+	// floats per icon vertex: position (3), tint (4), atlas cell (2), size (1)
+	iconStride = 10
+)
+
+var (
+	// shared styles and style maps by id, collected from the whole document
+	kmlStyles    = make(map[string]Style)
+	kmlStyleMaps = make(map[string]StyleMap)
+
+	// atlas cell index of each icon href
+	iconCells = make(map[string]int)
+
+	// number of cells per row and column of the icon atlas
+	iconAtlasColumns = 1
+)
+
+// collects the styles and style maps with an id from f and its subfolders
+func collectStyles(f Folder) {
+	for _, s := range f.Styles {
+		if s.ID != "" {
+			kmlStyles[s.ID] = s
+		}
+	}
+	for _, s := range f.StyleMaps {
+		if s.ID != "" {
+			kmlStyleMaps[s.ID] = s
+		}
+	}
+	for i := range f.Folders {
+		collectStyles(f.Folders[i])
+	}
+}
+
+// returns the style of a feature: its inline style, else the style (or normal style of the style map) named by styleUrl
+func resolveStyle(f Folder) (Style, bool) {
+	if len(f.Styles) > 0 {
+		return f.Styles[0], true
+	}
+
+	id := f.StyleURL[strings.LastIndex(f.StyleURL, "#")+1:]
+	if sm, ok := kmlStyleMaps[id]; ok {
+		for _, p := range sm.Pairs {
+			if p.Key == "normal" {
+				id = p.StyleURL[strings.LastIndex(p.StyleURL, "#")+1:]
+			}
+		}
+	}
+
+	s, ok := kmlStyles[id]
+	return s, ok
+}
+
+// returns the icon style of a feature if it has an icon
+func featureIconStyle(f Folder) (IconStyle, bool) {
+	s, ok := resolveStyle(f)
+	if !ok || s.IconStyle == nil || s.IconStyle.Icon.Href == "" {
+		return IconStyle{}, false
+	}
+	return *s.IconStyle, true
+}
+
+// returns every icon href used by the styles of the document
+func collectIconHrefs(f Folder) []string {
+	hrefs := []string{}
+	seen := make(map[string]bool)
+
+	add := func(s Style) {
+		if s.IconStyle != nil && s.IconStyle.Icon.Href != "" && !seen[s.IconStyle.Icon.Href] {
+			seen[s.IconStyle.Icon.Href] = true
+			hrefs = append(hrefs, s.IconStyle.Icon.Href)
+		}
+	}
+
+	var walk func(f Folder)
+	walk = func(f Folder) {
+		for _, s := range f.Styles {
+			add(s)
+		}
+		for i := range f.Folders {
+			walk(f.Folders[i])
+		}
+	}
+	walk(f)
+
+	return hrefs
+}
+
+// builds one texture holding the default icon (cell 0) and every icon in hrefs, each scaled to one cell
+func buildIconAtlas(hrefs []string) uint32 {
+	images := []*image.RGBA{}
+
+	if file, err := openAsset(defaultIconPath); err == nil {
+		if img, err := decodeImage(file); err == nil {
+			images = append(images, img)
+		}
+		file.Close()
+	}
+	if len(images) == 0 {
+		// plain white square, still tinted by IconStyle color
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		copy(img.Pix, []uint8{255, 255, 255, 255})
+		images = append(images, img)
+	}
+
+	for _, href := range hrefs {
+		img, ok := loadLinkedImage(href)
+		if !ok {
+			iconCells[href] = 0
+			continue
+		}
+		iconCells[href] = len(images)
+		images = append(images, img)
+	}
+
+	iconAtlasColumns = int(math.Ceil(math.Sqrt(float64(len(images)))))
+	size := iconAtlasColumns * iconCellSize
+	atlas := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i, img := range images {
+		copyScaled(atlas, img, (i%iconAtlasColumns)*iconCellSize, (i/iconAtlasColumns)*iconCellSize)
+	}
+
+	return textureFromPixels(atlas.Pix, int32(size), int32(size), colorRGBA)
+}
+
+// copies src into the iconCellSize square of dst at (x, y), nearest neighbour scaled
+func copyScaled(dst *image.RGBA, src *image.RGBA, x int, y int) {
+	b := src.Bounds()
+	for j := 0; j < iconCellSize; j++ {
+		for i := 0; i < iconCellSize; i++ {
+			sx := b.Min.X + i*b.Dx()/iconCellSize
+			sy := b.Min.Y + j*b.Dy()/iconCellSize
+			so := src.PixOffset(sx, sy)
+			do := dst.PixOffset(x+i, y+j)
+			copy(dst.Pix[do:do+4], src.Pix[so:so+4])
+		}
+	}
+}
+
+// parses a KML aabbggrr color, returning opaque white if it is missing or invalid
+func parseKMLColor(c string) mgl32.Vec4 {
+	v, err := strconv.ParseUint(strings.TrimSpace(c), 16, 32)
+	if err != nil || len(strings.TrimSpace(c)) != 8 {
+		return mgl32.Vec4{1, 1, 1, 1}
+	}
+	return mgl32.Vec4{
+		float32(v&0xff) / 255,
+		float32(v>>8&0xff) / 255,
+		float32(v>>16&0xff) / 255,
+		float32(v>>24&0xff) / 255,
+	}
+}
+
+// returns icon vertices for the points of a feature (6 floats per point, as from appendVert)
+func iconVertices(points []float32, style IconStyle) []float32 {
+	cell := iconCells[style.Icon.Href]
+	tint := parseKMLColor(style.Color)
+	scale := 1.0
+	if style.Scale != nil {
+		scale = *style.Scale
+	}
+	u := float32(cell%iconAtlasColumns) / float32(iconAtlasColumns)
+	v := float32(cell/iconAtlasColumns) / float32(iconAtlasColumns)
+
+	vertices := []float32{}
+	for i := 0; i+2 < len(points); i += 6 {
+		vertices = append(vertices, points[i], points[i+1], points[i+2],
+			tint[0], tint[1], tint[2], tint[3], u, v, float32(iconBaseSize*scale))
+	}
+	return vertices
+}
+
+// returns the model space position and drawn size in pixels of icon i
+func iconAt(icons []float32, i int32) (mgl32.Vec3, float32) {
+	return mgl32.Vec3{icons[i*iconStride], icons[i*iconStride+1], icons[i*iconStride+2]}, icons[i*iconStride+9]
+}
+
+// draws every icon as a point sprite in a single draw call
+func drawIcons(program *shaderProgram, vertexArray uint32, atlas uint32, count int32) {
+	if count == 0 {
+		return
+	}
+
+	program.use()
+	gl.Enable(gl.PROGRAM_POINT_SIZE)
+
+	gl.ActiveTexture(gl.TEXTURE5)
+	gl.BindTexture(gl.TEXTURE_2D, atlas)
+
+	gl.BindVertexArray(vertexArray)
+	gl.DrawArrays(gl.POINTS, 0, count)
+
+	gl.Disable(gl.PROGRAM_POINT_SIZE)
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"math"
+	"log"
 
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
-	"github.com/go-gl/mathgl/mgl32"
 )
 
 func processInput(window *glfw.Window, deltaTime float64) {
@@ -49,6 +48,19 @@ func processInput(window *glfw.Window, deltaTime float64) {
 }
 
 func keyCallBack(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	// Ctrl+number saves a bookmark, Alt+number recalls it
+	if key >= glfw.Key0 && key <= glfw.Key9 && action == glfw.Press && mods&(glfw.ModControl|glfw.ModAlt) != 0 {
+		n := int(key - glfw.Key0)
+		if mods&glfw.ModControl != 0 {
+			if err := setBookmark(n); err != nil {
+				log.Println("Could not save bookmark:", err)
+			}
+			refreshBookmarks()
+		} else {
+			recallBookmark(n)
+		}
+		return
+	}
 	if key == glfw.Key1 && action == glfw.Press {
 		state.showEarth = !state.showEarth
 	}
@@ -61,6 +73,39 @@ func keyCallBack(window *glfw.Window, key glfw.Key, scancode int, action glfw.Ac
 	if key == glfw.Key4 && action == glfw.Press {
 		state.showOrbits = !state.showOrbits
 	}
+	if key == glfw.Key5 && action == glfw.Press {
+		state.showLabels = !state.showLabels
+	}
+	if key == glfw.Key6 && action == glfw.Press {
+		state.showOverlays = !state.showOverlays
+	}
+	if key == glfw.Key7 && action == glfw.Press {
+		state.siderealRotation = !state.siderealRotation
+	}
+	if key == glfw.Key8 && action == glfw.Press {
+		state.showTerminator = !state.showTerminator
+	}
+	if key == glfw.Key9 && action == glfw.Press {
+		state.showSky = !state.showSky
+	}
+	if key == glfw.KeyG && action == glfw.Press {
+		state.showGraticule = !state.showGraticule
+	}
+	if key == glfw.KeyK && action == glfw.Press {
+		state.showCoastlines = !state.showCoastlines
+	}
+	if key == glfw.KeyB && action == glfw.Press {
+		state.showBorders = !state.showBorders
+	}
+	if key == glfw.KeyV && action == glfw.Press {
+		state.showCoverage = !state.showCoverage
+	}
+	if key == glfw.KeyT && action == glfw.Press {
+		state.showGroundTracks = !state.showGroundTracks
+	}
+	if key == glfw.KeyM && action == glfw.Press {
+		state.measuring = !state.measuring
+	}
 	if key == glfw.KeyEscape && action == glfw.Press {
 		state.inputting = !state.inputting
 	}
@@ -100,11 +145,7 @@ func mouseCallback(window *glfw.Window, xpos float64, ypos float64) {
 			mouse.pitch = -89.0
 		}
 
-		var front mgl32.Vec3
-		front[0] = float32(math.Cos(float64(mgl32.DegToRad(mouse.yaw))) * math.Cos(float64(mgl32.DegToRad(mouse.pitch))))
-		front[1] = float32(math.Sin(float64(mgl32.DegToRad(mouse.pitch))))
-		front[2] = float32(math.Sin(float64(mgl32.DegToRad(mouse.yaw))) * math.Cos(float64(mgl32.DegToRad(mouse.pitch))))
-		camera.Front = front.Normalize()
+		camera.Front = frontFromAngles(mouse.yaw, mouse.pitch)
 	}
 }
 
@@ -130,6 +171,11 @@ func fbCallback(window *glfw.Window, width int, height int) {
 
 func mouseButtonCallback(window *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
 	if button == glfw.MouseButtonLeft && action == glfw.Press {
+		// while measuring, clicks with the mouse unlocked pick the ends of a measurement
+		if state.measuring && !state.inputting {
+			queueMeasureClick(window.GetCursorPos())
+			return
+		}
 		state.inputting = !state.inputting
 	}
 	if state.inputting {
@@ -1,26 +1,48 @@
 package main
 
 import (
+	"archive/zip"
 	"encoding/xml"
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
 // Folder is basic xml group structure
 type Folder struct {
-	XMLName     xml.Name   `xml:"Folder"`
-	Name        string     `xml:"name"`
-	Folders     []Folder   `xml:"Folder"`
-	Visibility  bool       `xml:"visibility"`
-	StyleURL    string     `xml:"styleUrl"`
-	Point       Point      `xml:"Point"`
-	Description string     `xml:"description"`
-	LineString  LineString `xml:"LineString"`
-	Track       Track      `xml:"Track"`
-	ID          int
+	XMLName      xml.Name        `xml:"Folder"`
+	Name         string          `xml:"name"`
+	Folders      []Folder        `xml:"Folder"`
+	Visibility   bool            `xml:"visibility"`
+	StyleURL     string          `xml:"styleUrl"`
+	Point        Point           `xml:"Point"`
+	Description  string          `xml:"description"`
+	LineString   LineString      `xml:"LineString"`
+	Track        Track           `xml:"Track"`
+	LookAt       *LookAt         `xml:"LookAt"`
+	Camera       *CameraView     `xml:"Camera"`
+	Tours        []Tour          `xml:"Tour"`
+	Styles       []Style         `xml:"Style"`
+	StyleMaps    []StyleMap      `xml:"StyleMap"`
+	Grounds      []GroundOverlay `xml:"GroundOverlay"`
+	Screens      []ScreenOverlay `xml:"ScreenOverlay"`
+	ExtendedData ExtendedData    `xml:"ExtendedData"`
+	FeatureID    string          `xml:"id,attr"`
+	ID           int
+}
+
+// ExtendedData holds untyped name/value pairs of a feature
+type ExtendedData struct {
+	Data []Data `xml:"Data"`
+}
+
+// Data is one name/value pair of ExtendedData
+type Data struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
 }
 
 // Point has coordinate, altitude data
@@ -29,7 +51,7 @@ type Point struct {
 	Coordinates  string `xml:"coordinates"`
 }
 
-// LineString has two coords, altitudemode, etc
+// LineString has coords, altitudemode, etc
 type LineString struct {
 	AltitudeMode string `xml:"altitudeMode"`
 	Coordinates  string `xml:"coordinates"`
@@ -44,17 +66,129 @@ type Track struct {
 	Coords       []string `xml:"coord"`
 }
 
+// LookAt views a point on the earth from a distance, heading and tilt
+type LookAt struct {
+	Longitude    float64 `xml:"longitude"`
+	Latitude     float64 `xml:"latitude"`
+	Altitude     float64 `xml:"altitude"`
+	Heading      float64 `xml:"heading"`
+	Tilt         float64 `xml:"tilt"`
+	Range        float64 `xml:"range"`
+	AltitudeMode string  `xml:"altitudeMode"`
+}
+
+// CameraView is the KML <Camera> element (named to avoid clashing with Camera)
+type CameraView struct {
+	Longitude    float64 `xml:"longitude"`
+	Latitude     float64 `xml:"latitude"`
+	Altitude     float64 `xml:"altitude"`
+	Heading      float64 `xml:"heading"`
+	Tilt         float64 `xml:"tilt"`
+	Roll         float64 `xml:"roll"`
+	AltitudeMode string  `xml:"altitudeMode"`
+}
+
+// Style is a shared or inline KML style. only IconStyle is used, line colors come from getColor
+type Style struct {
+	ID        string     `xml:"id,attr"`
+	IconStyle *IconStyle `xml:"IconStyle"`
+}
+
+// IconStyle sets the image, size and tint of a point's icon
+type IconStyle struct {
+	Color string   `xml:"color"`
+	Scale *float64 `xml:"scale"`
+	Icon  Icon     `xml:"Icon"`
+}
+
+// Icon links to an image by href
+type Icon struct {
+	Href string `xml:"href"`
+}
+
+// GroundOverlay drapes an image over a LatLonBox on the earth
+type GroundOverlay struct {
+	Name         string    `xml:"name"`
+	Visibility   *bool     `xml:"visibility"`
+	Color        string    `xml:"color"`
+	Icon         Icon      `xml:"Icon"`
+	Altitude     float64   `xml:"altitude"`
+	AltitudeMode string    `xml:"altitudeMode"`
+	LatLonBox    LatLonBox `xml:"LatLonBox"`
+}
+
+// LatLonBox is the area covered by a ground overlay, in degrees
+type LatLonBox struct {
+	North    float64 `xml:"north"`
+	South    float64 `xml:"south"`
+	East     float64 `xml:"east"`
+	West     float64 `xml:"west"`
+	Rotation float64 `xml:"rotation"`
+}
+
+// ScreenOverlay is an image fixed to the window, such as a legend or logo
+type ScreenOverlay struct {
+	Name       string    `xml:"name"`
+	Visibility *bool     `xml:"visibility"`
+	Color      string    `xml:"color"`
+	Icon       Icon      `xml:"Icon"`
+	OverlayXY  OverlayXY `xml:"overlayXY"`
+	ScreenXY   OverlayXY `xml:"screenXY"`
+	Size       OverlayXY `xml:"size"`
+	Rotation   float64   `xml:"rotation"`
+}
+
+// OverlayXY is a point or size in fraction, pixels or insetPixels units
+type OverlayXY struct {
+	X      float64 `xml:"x,attr"`
+	Y      float64 `xml:"y,attr"`
+	XUnits string  `xml:"xunits,attr"`
+	YUnits string  `xml:"yunits,attr"`
+}
+
+// StyleMap picks a style for normal and highlighted display, only the normal style is used
+type StyleMap struct {
+	ID    string `xml:"id,attr"`
+	Pairs []struct {
+		Key      string `xml:"key"`
+		StyleURL string `xml:"styleUrl"`
+	} `xml:"Pair"`
+}
+
+// the archive the document was read from when it is a KMZ, used to load icons
+var kmzArchive *zip.ReadCloser
+
+// start and end tags of the elements read as folders, and the CDATA sections and comments they may not be renamed in
+var featureElement = regexp.MustCompile(`<!\[CDATA\[[\s\S]*?\]\]>|<!--[\s\S]*?-->|</?([\w.-]+:)?(Placemark|Document|kml)[\s/>]`)
+
+// renames the kml, Document and Placemark elements of a KML document to Folder, so the whole tree reads
+// into nested Folders. only element names are changed, never text, attributes, CDATA or comments
+func renameFeatureElements(doc string) string {
+	return featureElement.ReplaceAllStringFunc(doc, func(tag string) string {
+		if strings.HasPrefix(tag, "<!") {
+			return tag
+		}
+		open := "<"
+		if strings.HasPrefix(tag, "</") {
+			open = "</"
+		}
+		prefix := featureElement.FindStringSubmatch(tag)[1]
+		return open + prefix + "Folder" + tag[len(tag)-1:]
+	})
+}
+
 func readKML(filename string, eventIndex int) Folder {
 	// load the KML document
 
 	// read our opened xmlFile as a byte array.
-	old, _ := ioutil.ReadFile(filename)
-
-	newXML := strings.Replace(string(old), "Placemark", "Folder", -1)
-	newXML = strings.Replace(string(newXML), "Document", "Folder", -1)
-	newXML = strings.Replace(string(newXML), "kml", "Folder", -1)
+	var old []byte
+	if strings.HasSuffix(strings.ToLower(filename), ".kmz") {
+		old = readKMZ(filename)
+	} else {
+		old, _ = ioutil.ReadFile(filename)
+	}
 
-	byteValue := []byte(newXML)
+	byteValue := []byte(renameFeatureElements(string(old)))
 
 	// we initialize our Users array
 	var kml Folder
@@ -66,48 +200,139 @@ func readKML(filename string, eventIndex int) Folder {
 	return kml
 }
 
-func interpretSelected() ([]float32, int, int) {
+// opens a KMZ archive and returns its main KML document (doc.kml, or the first .kml file in it)
+func readKMZ(filename string) []byte {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil
+	}
+	kmzArchive = archive
+
+	var doc *zip.File
+	for _, f := range archive.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".kml") {
+			continue
+		}
+		if doc == nil || f.Name == "doc.kml" {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil
+	}
+
+	r, err := doc.Open()
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+
+	data, _ := ioutil.ReadAll(r)
+	return data
+}
+
+// returns the folder at the given path of tree node names (as stored in node references)
+func lookupFolder(path []string) (Folder, bool) {
+	if len(kml.Folders) == 0 || len(path) == 0 {
+		return Folder{}, false
+	}
+
+	f := kml.Folders[0]
+	for _, name := range path {
+		i, ok := m[name]
+		if !ok || i >= len(f.Folders) {
+			return Folder{}, false
+		}
+		f = f.Folders[i]
+	}
+
+	return f, true
+}
+
+func interpretSelected() ([]float32, []float32, int, int, int) {
 	vertices := []float32{}
 	points := []float32{}
 	orbices := []float32{}
+	grounds := []float32{}
+	icons := []float32{}
+	pickFeatures = []feature{}
+	groundTicks = []groundTick{}
+	coverageSources = []coverageSource{}
 	//app.Stop()
-	if len(selected) == 0 {
-		return vertices, 0, 0
-	}
-
-	doc := kml.Folders[0]
 
 	mutex.Lock()
 
 	for i := range selected {
-		switch len(selected[i]) {
-		case 1:
-			//fmt.Println(doc.Folders[m[selected[i][0]]].Name)
-			verts, ponts, orbs := appendVert(doc.Folders[m[selected[i][0]]])
-			vertices = append(vertices, verts...)
-			points = append(points, ponts...)
-			orbices = append(orbices, orbs...)
-		case 2:
-			//fmt.Println(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]].Name)
-			verts, ponts, orbs := appendVert(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]])
-			vertices = append(vertices, verts...)
-			points = append(points, ponts...)
-			orbices = append(orbices, orbs...)
-		case 3:
-			//fmt.Println(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]].Folders[m[selected[i][2]]].Name)
-			verts, ponts, orbs := appendVert(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]].Folders[m[selected[i][2]]])
-			vertices = append(vertices, verts...)
-			points = append(points, ponts...)
-			orbices = append(orbices, orbs...)
-		case 4:
-			//fmt.Println(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]].Folders[m[selected[i][2]]].Folders[m[selected[i][3]]].Name)
-			verts, ponts, orbs := appendVert(doc.Folders[m[selected[i][0]]].Folders[m[selected[i][1]]].Folders[m[selected[i][2]]].Folders[m[selected[i][3]]])
-			vertices = append(vertices, verts...)
-			points = append(points, ponts...)
-			orbices = append(orbices, orbs...)
+		f, ok := lookupFolder(selected[i])
+		if !ok {
+			continue
+		}
+		frame := featureFrame(selected[i])
+		verts, ponts, orbs := appendVert(f)
+		grnds, ticks, groundFixed := groundTrackVertices(f, frame)
+
+		// points with an IconStyle are drawn as icons instead of dots
+		icns := []float32{}
+		if style, ok := featureIconStyle(f); ok && len(ponts) > 0 {
+			icns = iconVertices(ponts, style)
+			ponts = []float32{}
+		}
+
+		pickFeatures = append(pickFeatures, feature{
+			Name:        f.Name,
+			Description: f.Description,
+			lines:       vertexRange{int32(len(vertices) / 6), int32(len(verts) / 6)},
+			points:      vertexRange{int32(len(points) / 6), int32(len(ponts) / 6)},
+			orbits:      vertexRange{int32(len(orbices) / 6), int32(len(orbs) / 6)},
+			groundTrack: vertexRange{int32(len(grounds) / 6), int32(len(grnds) / 6)},
+			icons:       vertexRange{int32(len(icons) / iconStride), int32(len(icns) / iconStride)},
+			inertial:    frame == frameECI,
+			groundFixed: groundFixed,
+		})
+		for _, t := range ticks {
+			t.vertex += int32(len(grounds) / 6)
+			groundTicks = append(groundTicks, t)
+		}
+
+		vertices = append(vertices, verts...)
+		points = append(points, ponts...)
+		orbices = append(orbices, orbs...)
+		grounds = append(grounds, grnds...)
+		icons = append(icons, icns...)
+
+		if spec, ok := pathCone(f, selected[i]); ok {
+			r, g, b := getColor(f.StyleURL)
+			coverageSources = append(coverageSources, coverageSource{
+				folder: f,
+				frame:  frame,
+				spec:   spec,
+				color:  [3]float32{r, g, b},
+			})
 		}
 	}
 
+	// computed lines of sight are drawn and picked like selected line features
+	for i := range losResults {
+		verts := losResults[i].vertices()
+		pickFeatures = append(pickFeatures, feature{
+			Name:        losResults[i].name,
+			Description: losResults[i].description(),
+			lines:       vertexRange{int32(len(vertices) / 6), int32(len(verts) / 6)},
+		})
+		vertices = append(vertices, verts...)
+	}
+
+	// measurements too, labelled with their range and angles
+	for i := range measurements {
+		verts := measurements[i].vertices()
+		pickFeatures = append(pickFeatures, feature{
+			Name:        measurements[i].name,
+			Description: reportHTML(measurements[i].report),
+			lines:       vertexRange{int32(len(vertices) / 6), int32(len(verts) / 6)},
+		})
+		vertices = append(vertices, verts...)
+	}
+
 	mutex.Unlock()
 
 	pointStart := len(vertices)
@@ -118,82 +343,114 @@ func interpretSelected() ([]float32, int, int) {
 
 	vertices = append(vertices, orbices...)
 
-	//app.Stop()
-
-	//fmt.Println(m)
-
-	return vertices, pointStart, orbitStart
-}
+	groundStart := len(vertices)
 
-func appendVert(f Folder) ([]float32, []float32, []float32) {
-	s := f.LineString.Coordinates
-	st := f.StyleURL
-	so := f.Track.Coords
-	vertices := []float32{}
-	points := []float32{}
-	orbitVertices := []float32{}
-	sp := f.Point.Coordinates
+	vertices = append(vertices, grounds...)
 
-	if len(s) > 0 {
-		pos1 := strings.Split(strings.Fields(s)[0], ",")
-		pos2 := strings.Split(strings.Fields(s)[1], ",")
+	// make the point, orbit and ground track ranges relative to the start of the kml vertices
+	for i := range pickFeatures {
+		pickFeatures[i].points.first += int32(pointStart / 6)
+		pickFeatures[i].orbits.first += int32(orbitStart / 6)
+		pickFeatures[i].groundTrack.first += int32(groundStart / 6)
+	}
+	for i := range groundTicks {
+		groundTicks[i].vertex += int32(groundStart / 6)
+	}
 
-		pos1Lat, _ := strconv.ParseFloat(pos1[0], 64)
-		pos1Lon, _ := strconv.ParseFloat(pos1[1], 64)
-		pos1Alt, _ := strconv.ParseFloat(pos1[2], 64)
+	//app.Stop()
 
-		pos2Lat, _ := strconv.ParseFloat(pos2[0], 64)
-		pos2Lon, _ := strconv.ParseFloat(pos2[1], 64)
-		pos2Alt, _ := strconv.ParseFloat(pos2[2], 64)
+	//fmt.Println(m)
 
-		pos1X, pos1Y, pos1Z := latLonToVertex(pos1Lon, pos1Lat, pos1Alt)
-		pos2X, pos2Y, pos2Z := latLonToVertex(pos2Lon, pos2Lat, pos2Alt)
+	return vertices, icons, pointStart, orbitStart, groundStart
+}
 
-		r, g, b := getColor(st)
+// Coordinate is a geodetic position from a KML coordinate tuple
+type Coordinate struct {
+	Lon float64
+	Lat float64
+	Alt float64
+}
 
-		vertices = append(vertices, pos1X, pos1Y, pos1Z, r, g, b, pos2X, pos2Y, pos2Z, r, g, b)
+// whitespace around the commas of a coordinate tuple, which some writers put in
+var coordinateComma = regexp.MustCompile(`\s*,\s*`)
+
+// parses KML coordinates: tuples of "lon,lat[,alt]" separated by whitespace, as in <coordinates>,
+// or a single whitespace separated "lon lat [alt]" tuple without commas, as in <gx:coord>.
+// a missing or empty altitude is 0, tuples that can't be parsed are skipped
+func parseCoordinates(s string) []Coordinate {
+	tuples := [][]string{}
+	if strings.Contains(s, ",") {
+		for _, t := range strings.Fields(coordinateComma.ReplaceAllString(s, ",")) {
+			tuples = append(tuples, strings.Split(t, ","))
+		}
+	} else if fields := strings.Fields(s); len(fields) > 0 {
+		tuples = append(tuples, fields)
 	}
 
-	if len(sp) > 0 {
-		pos1 := strings.Split(strings.Fields(sp)[0], ",")
-
-		pos1Lat, _ := strconv.ParseFloat(pos1[0], 64)
-		pos1Lon, _ := strconv.ParseFloat(pos1[1], 64)
-		pos1Alt, _ := strconv.ParseFloat(pos1[2], 64)
+	coords := []Coordinate{}
+	for _, t := range tuples {
+		if len(t) < 2 || len(t) > 3 {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(t[0], 64)
+		lat, err2 := strconv.ParseFloat(t[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		c := Coordinate{Lon: lon, Lat: lat}
+		if len(t) == 3 && t[2] != "" {
+			alt, err := strconv.ParseFloat(t[2], 64)
+			if err != nil {
+				continue
+			}
+			c.Alt = alt
+		}
+		coords = append(coords, c)
+	}
+	return coords
+}
 
-		pos1X, pos1Y, pos1Z := latLonToVertex(pos1Lon, pos1Lat-45.492, pos1Alt)
+// returns the model space position of a coordinate in an altitude mode, with the frame offset applied
+func coordinateVertex(c Coordinate, mode string) (float32, float32, float32) {
+	lon := c.Lon + frameLonOffset
+	return latLonToVertex(c.Lat, lon, altitudeAboveEllipsoid(c.Lat, lon, c.Alt, mode))
+}
 
-		r, g, b := getColor(st)
+// returns the line, point and orbit vertices of a feature. line strings become one segment per
+// pair of consecutive coordinates, tracks a closed loop through their coordinates
+func appendVert(f Folder) ([]float32, []float32, []float32) {
+	vertices := []float32{}
+	points := []float32{}
+	orbitVertices := []float32{}
+	r, g, b := getColor(f.StyleURL)
 
-		points = append(points, pos1X, pos1Y, pos1Z, r, g, b)
+	line := parseCoordinates(f.LineString.Coordinates)
+	for i := 0; i+1 < len(line); i++ {
+		x1, y1, z1 := coordinateVertex(line[i], f.LineString.AltitudeMode)
+		x2, y2, z2 := coordinateVertex(line[i+1], f.LineString.AltitudeMode)
+		vertices = append(vertices, x1, y1, z1, r, g, b, x2, y2, z2, r, g, b)
 	}
 
-	if len(so) > 0 {
-		r, g, b := getColor(st)
-		for i := range so {
-			pos1 := strings.Split(strings.Fields(so[i])[0], ",")
-
-			pos1Lat, _ := strconv.ParseFloat(pos1[0], 64)
-			pos1Lon, _ := strconv.ParseFloat(pos1[1], 64)
-			pos1Alt, _ := strconv.ParseFloat(pos1[2], 64)
-
-			pos1X, pos1Y, pos1Z := latLonToVertex(pos1Lon, pos1Lat, pos1Alt)
+	if point := parseCoordinates(f.Point.Coordinates); len(point) > 0 {
+		x, y, z := coordinateVertex(point[0], f.Point.AltitudeMode)
+		points = append(points, x, y, z, r, g, b)
+	}
 
+	track := []Coordinate{}
+	for _, c := range f.Track.Coords {
+		track = append(track, parseCoordinates(c)...)
+	}
+	if len(track) > 0 {
+		for i, c := range track {
+			x, y, z := coordinateVertex(c, f.Track.AltitudeMode)
 			if i > 0 {
-				orbitVertices = append(orbitVertices, pos1X, pos1Y, pos1Z, r, g, b)
+				orbitVertices = append(orbitVertices, x, y, z, r, g, b)
 			}
-			orbitVertices = append(orbitVertices, pos1X, pos1Y, pos1Z, r, g, b)
+			orbitVertices = append(orbitVertices, x, y, z, r, g, b)
 		}
 
-		pos1 := strings.Split(strings.Fields(so[0])[0], ",")
-
-		pos1Lat, _ := strconv.ParseFloat(pos1[0], 64)
-		pos1Lon, _ := strconv.ParseFloat(pos1[1], 64)
-		pos1Alt, _ := strconv.ParseFloat(pos1[2], 64)
-
-		pos1X, pos1Y, pos1Z := latLonToVertex(pos1Lon, pos1Lat, pos1Alt)
-
-		orbitVertices = append(orbitVertices, pos1X, pos1Y, pos1Z, r, g, b)
+		x, y, z := coordinateVertex(track[0], f.Track.AltitudeMode)
+		orbitVertices = append(orbitVertices, x, y, z, r, g, b)
 	}
 
 	return vertices, points, orbitVertices
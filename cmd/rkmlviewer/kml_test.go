@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCoordinates(t *testing.T) {
+	tests := []struct {
+		name        string
+		coordinates string
+		want        []Coordinate
+	}{
+		{"empty", "", []Coordinate{}},
+		{"single tuple", "10.5,-20.25,300", []Coordinate{{Lon: 10.5, Lat: -20.25, Alt: 300}}},
+		{"missing altitude", "10,20", []Coordinate{{Lon: 10, Lat: 20}}},
+		{"empty altitude", "10,20,", []Coordinate{{Lon: 10, Lat: 20}}},
+		{"several tuples", "\n\t1,2,3 4,5,6\n\t7,8\n", []Coordinate{{Lon: 1, Lat: 2, Alt: 3}, {Lon: 4, Lat: 5, Alt: 6}, {Lon: 7, Lat: 8}}},
+		{"space after comma", "10,20, 30", []Coordinate{{Lon: 10, Lat: 20, Alt: 30}}},
+		{"spaces around commas", "1 , 2 , 3 4, 5", []Coordinate{{Lon: 1, Lat: 2, Alt: 3}, {Lon: 4, Lat: 5}}},
+		{"gx:coord", "10 20 30", []Coordinate{{Lon: 10, Lat: 20, Alt: 30}}},
+		{"gx:coord without altitude", "10 20", []Coordinate{{Lon: 10, Lat: 20}}},
+		{"too few values", "10", []Coordinate{}},
+		{"too many values", "1,2,3,4 5,6", []Coordinate{{Lon: 5, Lat: 6}}},
+		{"not a number", "a,2,3 4,5,b 7,8,9", []Coordinate{{Lon: 7, Lat: 8, Alt: 9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCoordinates(tt.coordinates); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCoordinates(%q) = %v, want %v", tt.coordinates, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameFeatureElements(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"elements", "<kml><Document><Placemark></Placemark></Document></kml>",
+			"<Folder><Folder><Folder></Folder></Folder></Folder>"},
+		{"attributes", `<kml xmlns="http://www.opengis.net/kml/2.2"><Placemark id="kml1">`,
+			`<Folder xmlns="http://www.opengis.net/kml/2.2"><Folder id="kml1">`},
+		{"empty element", "<Placemark/><Document\n/>", "<Folder/><Folder\n/>"},
+		{"namespace prefix", "<kml:kml><kml:Placemark></kml:Placemark>", "<kml:Folder><kml:Folder></kml:Folder>"},
+		{"text", "<href>icons/kml/Placemark.png</href>", "<href>icons/kml/Placemark.png</href>"},
+		{"longer names", "<kmlx><PlacemarkStyle><DocumentSource>", "<kmlx><PlacemarkStyle><DocumentSource>"},
+		{"cdata", "<description><![CDATA[<b>kml</b> <Placemark> text]]></description>",
+			"<description><![CDATA[<b>kml</b> <Placemark> text]]></description>"},
+		{"comment", "<!-- <Document> --><Document>", "<!-- <Document> --><Folder>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renameFeatureElements(tt.doc); got != tt.want {
+				t.Errorf("renameFeatureElements(%q) = %q, want %q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadKMLKeepsText(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+	<name>Document of kml Placemarks</name>
+	<Style id="icon"><IconStyle><Icon><href>kml/icons/Placemark.png</href></Icon></IconStyle></Style>
+	<Placemark>
+		<name>Site</name>
+		<description><![CDATA[A <b>Placemark</b> from a kml Document]]></description>
+		<Point><coordinates>10,20,0</coordinates></Point>
+	</Placemark>
+</Document>
+</kml>`
+	path := filepath.Join(t.TempDir(), "test.kml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	kml := readKML(path, 0)
+	if len(kml.Folders) != 1 || len(kml.Folders[0].Folders) != 1 {
+		t.Fatalf("readKML() = %+v, want a document with one placemark", kml)
+	}
+	document, placemark := kml.Folders[0], kml.Folders[0].Folders[0]
+	if document.Name != "Document of kml Placemarks" {
+		t.Errorf("document name = %q", document.Name)
+	}
+	if len(document.Styles) != 1 || document.Styles[0].IconStyle == nil ||
+		document.Styles[0].IconStyle.Icon.Href != "kml/icons/Placemark.png" {
+		t.Errorf("document styles = %+v, want the icon href kml/icons/Placemark.png", document.Styles)
+	}
+	if placemark.Description != "A <b>Placemark</b> from a kml Document" {
+		t.Errorf("placemark description = %q", placemark.Description)
+	}
+	if placemark.Point.Coordinates != "10,20,0" {
+		t.Errorf("placemark coordinates = %q, want 10,20,0", placemark.Point.Coordinates)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// glyph size in pixels and layout of the font atlas (ASCII 32-127, 16 glyphs per row)
+	glyphWidth   = 7
+	glyphHeight  = 13
+	atlasColumns = 16
+	atlasRows    = 6
+
+	// labels are fully opaque closer than labelFadeNear and hidden beyond labelFadeFar (earth radii), counted
+	// from the camera's height above the globe so the ground below is never faded at any zoom level
+	labelFadeNear = 3.0
+	labelFadeFar  = 12.0
+
+	// gap in pixels between a feature and its label, and around labels when decluttering
+	labelOffset  = 6
+	labelPadding = 2
+
+	// floats per label vertex: position (2), texture coordinate (2), color (4)
+	labelStride = 8
+)
+
+// label is a feature name waiting to be placed on screen
+type label struct {
+	text  string
+	pos   mgl32.Vec2
+	dist  float32
+	alpha float32
+}
+
+// returns the model space point a feature's label is attached to:
+// its icon or point, else the start of its orbit, else the far end of its first line
+func featureAnchor(vertices []float32, icons []float32, f feature) (mgl32.Vec3, bool) {
+	switch {
+	case f.icons.count > 0 && state.showPoints:
+		pos, _ := iconAt(icons, f.icons.first)
+		return pos, true
+	case f.points.count > 0 && state.showPoints:
+		return vertexPos(vertices, f.points.first), true
+	case f.orbits.count > 0 && state.showOrbits:
+		return vertexPos(vertices, f.orbits.first), true
+	case f.lines.count > 1 && state.showLines:
+		return vertexPos(vertices, f.lines.first+1), true
+	}
+	return mgl32.Vec3{}, false
+}
+
+// returns the label quads for the drawn features and the graticule. labels fade out with distance from
+// the camera, and labels that would overlap a closer label are left out
+func buildLabels(vertices []float32, icons []float32, mvp mgl32.Mat4, model mgl32.Mat4, eye mgl32.Vec3) []float32 {
+	labels := []label{}
+	for _, f := range pickFeatures {
+		if f.Name == "" {
+			continue
+		}
+		anchor, ok := featureAnchor(vertices, icons, f)
+		if !ok {
+			continue
+		}
+		if l, ok := newLabel(f.Name, anchor, mvp, model, eye); ok {
+			labels = append(labels, l)
+		}
+	}
+
+	// closest labels win when they overlap, feature names before the graticule
+	sort.Slice(labels, func(i, j int) bool { return labels[i].dist < labels[j].dist })
+	if state.showGroundTracks {
+		ticks := groundTickLabels(vertices, mvp, model, eye)
+		sort.Slice(ticks, func(i, j int) bool { return ticks[i].dist < ticks[j].dist })
+		labels = append(labels, ticks...)
+	}
+	if state.showGraticule {
+		grid := graticuleLabels(mvp, model, eye)
+		sort.Slice(grid, func(i, j int) bool { return grid[i].dist < grid[j].dist })
+		labels = append(labels, grid...)
+	}
+
+	quads := []float32{}
+	placed := [][4]float32{}
+	for _, l := range labels {
+		x := float32(math.Round(float64(l.pos[0]))) + labelOffset
+		y := float32(math.Round(float64(l.pos[1]))) - glyphHeight/2
+		rect := [4]float32{x - labelPadding, y - labelPadding, x + float32(utf8.RuneCountInString(l.text)*glyphWidth) + labelPadding, y + glyphHeight + labelPadding}
+
+		if rect[2] < 0 || rect[0] > float32(width) || rect[3] < 0 || rect[1] > float32(height) {
+			continue
+		}
+
+		overlaps := false
+		for _, p := range placed {
+			if rect[0] < p[2] && rect[2] > p[0] && rect[1] < p[3] && rect[3] > p[1] {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+		placed = append(placed, rect)
+
+		// dark shadow first so the text is readable over the bright earth
+		quads = appendText(quads, l.text, x+1, y+1, mgl32.Vec4{0, 0, 0, l.alpha})
+		quads = appendText(quads, l.text, x, y, mgl32.Vec4{1, 1, 1, l.alpha})
+	}
+
+	return quads
+}
+
+// returns the label of text attached to a model space point, false if the point is too far,
+// behind the earth or off screen
+func newLabel(text string, anchor mgl32.Vec3, mvp mgl32.Mat4, model mgl32.Mat4, eye mgl32.Vec3) (label, bool) {
+	world := model.Mul4x1(anchor.Vec4(1)).Vec3()
+	dist := world.Sub(eye).Len()
+	height := float32(math.Max(0, float64(eye.Len())-radius))
+	alpha := 1 - (dist-height-labelFadeNear)/(labelFadeFar-labelFadeNear)
+	if alpha <= 0 {
+		return label{}, false
+	}
+	if state.showEarth && occluded(eye, world) {
+		return label{}, false
+	}
+	pos, ok := projectToScreen(anchor, mvp)
+	if !ok {
+		return label{}, false
+	}
+	return label{text: text, pos: pos, dist: dist, alpha: mgl32.Clamp(alpha, 0, 1)}, true
+}
+
+// appends two triangles per character of text, top left corner at (x, y) in pixels
+func appendText(vertices []float32, text string, x float32, y float32, color mgl32.Vec4) []float32 {
+	for _, c := range text {
+		if c < 32 || c > 127 {
+			c = '?'
+		}
+		i := int(c) - 32
+		u0 := float32(i%atlasColumns) / atlasColumns
+		v0 := float32(i/atlasColumns) / atlasRows
+		u1 := u0 + 1.0/atlasColumns
+		v1 := v0 + 1.0/atlasRows
+		x1 := x + glyphWidth
+		y1 := y + glyphHeight
+
+		vertices = append(vertices,
+			x, y, u0, v0, color[0], color[1], color[2], color[3],
+			x1, y, u1, v0, color[0], color[1], color[2], color[3],
+			x1, y1, u1, v1, color[0], color[1], color[2], color[3],
+			x, y, u0, v0, color[0], color[1], color[2], color[3],
+			x1, y1, u1, v1, color[0], color[1], color[2], color[3],
+			x, y1, u0, v1, color[0], color[1], color[2], color[3],
+		)
+		x = x1
+	}
+	return vertices
+}
+
+// draws label vertices built by buildLabels on top of the scene
+func drawLabels(program *shaderProgram, vertexArray uint32, vertexBuffer uint32, fontTexture uint32, vertices []float32) {
+	if len(vertices) == 0 {
+		return
+	}
+
+	program.use()
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+
+	gl.ActiveTexture(gl.TEXTURE4)
+	gl.BindTexture(gl.TEXTURE_2D, fontTexture)
+
+	gl.BindVertexArray(vertexArray)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vertexBuffer)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.DYNAMIC_DRAW)
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(len(vertices)/labelStride))
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	gl.Enable(gl.DEPTH_TEST)
+	if !state.enableBlending {
+		gl.Disable(gl.BLEND)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// how deep (m) a line may dip below the ellipsoid and still count as in sight, so that points
+	// on the surface can see up to their horizon
+	losTolerance = 1.0
+)
+
+var (
+	// colors of computed lines of sight, like those of the precomputed ones
+	losVisibleColor = [3]float32{1, 1, 0}
+	losBlockedColor = [3]float32{1, 0, 0}
+
+	// the feature marked as the first end of a line of sight in the gui, nil until one is. guarded by mutex
+	losFrom *losEnd
+
+	// computed lines of sight, drawn after the selected features. guarded by mutex
+	losResults []losResult
+)
+
+// losEnd is one end of a line of sight: a feature and its positions
+type losEnd struct {
+	name      string
+	positions []timedPosition
+}
+
+// timedPosition is a position of a feature in the earth fixed frame (m), at a time if the feature has time stamps
+type timedPosition struct {
+	when time.Time
+	pos  [3]float64
+}
+
+// losSample is the line between the two ends at one time (or one pair of positions without times)
+type losSample struct {
+	when     time.Time
+	from, to [3]float64
+	visible  bool
+}
+
+// losResult is a computed line of sight, drawn and picked like a feature
+type losResult struct {
+	name    string
+	report  []string
+	samples []losSample
+}
+
+// returns the positions of a feature in the earth fixed frame: its point, the coordinates of its line,
+// or the coordinates of its track, with their times if every coordinate has a valid <when>.
+// frame is the frame of the feature's coordinates, inertial positions are turned into the earth
+// fixed frame at their time (or by the current earth rotation if they have none)
+func featurePositions(f Folder, frame string) ([]timedPosition, error) {
+	type coordinate struct {
+		c    Coordinate
+		mode string
+		when time.Time
+	}
+	coords := []coordinate{}
+
+	track := []Coordinate{}
+	for _, c := range f.Track.Coords {
+		track = append(track, parseCoordinates(c)...)
+	}
+	switch {
+	case len(track) > 0:
+		timed := len(f.Track.Whens) == len(track)
+		for i, c := range track {
+			coords = append(coords, coordinate{c: c, mode: f.Track.AltitudeMode})
+			if timed {
+				t, err := time.Parse(time.RFC3339, strings.TrimSpace(f.Track.Whens[i]))
+				timed = err == nil
+				coords[i].when = t.UTC()
+			}
+		}
+		if !timed {
+			for i := range coords {
+				coords[i].when = time.Time{}
+			}
+		}
+	case f.Point.Coordinates != "":
+		for _, c := range parseCoordinates(f.Point.Coordinates) {
+			coords = append(coords, coordinate{c: c, mode: f.Point.AltitudeMode})
+		}
+	default:
+		for _, c := range parseCoordinates(f.LineString.Coordinates) {
+			coords = append(coords, coordinate{c: c, mode: f.LineString.AltitudeMode})
+		}
+	}
+	if len(coords) == 0 {
+		return nil, fmt.Errorf("%s has no point, line or track", f.Name)
+	}
+
+	positions := []timedPosition{}
+	for _, c := range coords {
+		lon := c.c.Lon + frameLonOffset
+		p := geodeticToECEF(c.c.Lat, lon, altitudeAboveEllipsoid(c.c.Lat, lon, c.c.Alt, c.mode))
+		if frame == frameECI {
+			// without a time, turn it like the drawn inertial features
+			angle := earthRotation()
+			if !c.when.IsZero() {
+				angle = gmst(c.when)
+			}
+			sin, cos := math.Sincos(-angle * (math.Pi / 180))
+			p = [3]float64{p[0]*cos - p[1]*sin, p[0]*sin + p[1]*cos, p[2]}
+		}
+		positions = append(positions, timedPosition{when: c.when, pos: p})
+	}
+	return positions, nil
+}
+
+// returns the position of a timed track at t, interpolated between its samples. false outside the track
+func positionAt(track []timedPosition, t time.Time) ([3]float64, bool) {
+	i := sort.Search(len(track), func(i int) bool { return !track[i].when.Before(t) })
+	switch {
+	case i == len(track):
+		return [3]float64{}, false
+	case track[i].when.Equal(t):
+		return track[i].pos, true
+	case i == 0:
+		return [3]float64{}, false
+	}
+	p, q := track[i-1], track[i]
+	f := float64(t.Sub(p.when)) / float64(q.when.Sub(p.when))
+	return [3]float64{
+		p.pos[0] + (q.pos[0]-p.pos[0])*f,
+		p.pos[1] + (q.pos[1]-p.pos[1])*f,
+		p.pos[2] + (q.pos[2]-p.pos[2])*f,
+	}, true
+}
+
+// returns true if the straight line between two earth fixed positions (m) doesn't pass through the ellipsoid
+func clearLineOfSight(p [3]float64, q [3]float64) bool {
+	// stretch the ellipsoid into a sphere of the equatorial radius and find the closest point of the line to its center
+	p[2] *= a / polarRadius
+	q[2] *= a / polarRadius
+	d := [3]float64{q[0] - p[0], q[1] - p[1], q[2] - p[2]}
+	dd := d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	t := 0.0
+	if dd > 0 {
+		t = math.Max(0, math.Min(1, -(p[0]*d[0]+p[1]*d[1]+p[2]*d[2])/dd))
+	}
+	c := [3]float64{p[0] + d[0]*t, p[1] + d[1]*t, p[2] + d[2]*t}
+	return math.Sqrt(c[0]*c[0]+c[1]*c[1]+c[2]*c[2]) >= a-losTolerance
+}
+
+// pairs the positions of two features and tests each pair for line of sight. a single position is paired with
+// every position of the other feature, two timed tracks are paired at the times of the first (where the second
+// covers them), anything else by index
+func lineOfSight(from []timedPosition, to []timedPosition) []losSample {
+	samples := []losSample{}
+	add := func(when time.Time, p [3]float64, q [3]float64) {
+		samples = append(samples, losSample{when: when, from: p, to: q, visible: clearLineOfSight(p, q)})
+	}
+
+	switch {
+	case len(from) == 1:
+		for _, q := range to {
+			add(q.when, from[0].pos, q.pos)
+		}
+	case len(to) == 1:
+		for _, p := range from {
+			add(p.when, p.pos, to[0].pos)
+		}
+	case !from[0].when.IsZero() && !to[0].when.IsZero():
+		for _, p := range from {
+			if q, ok := positionAt(to, p.when); ok {
+				add(p.when, p.pos, q)
+			}
+		}
+	default:
+		for i := 0; i < len(from) && i < len(to); i++ {
+			add(time.Time{}, from[i].pos, to[i].pos)
+		}
+	}
+	return samples
+}
+
+// returns the first and last sample of each run of samples in sight
+func accessIntervals(samples []losSample) [][2]int {
+	intervals := [][2]int{}
+	for i, s := range samples {
+		if !s.visible {
+			continue
+		}
+		if n := len(intervals); n > 0 && intervals[n-1][1] == i-1 {
+			intervals[n-1][1] = i
+		} else {
+			intervals = append(intervals, [2]int{i, i})
+		}
+	}
+	return intervals
+}
+
+// computes the line of sight between two features and reports its access intervals
+func newLOSResult(from *losEnd, to *losEnd) (losResult, error) {
+	samples := lineOfSight(from.positions, to.positions)
+	if len(samples) == 0 {
+		return losResult{}, fmt.Errorf("%s and %s have no positions at the same times", from.name, to.name)
+	}
+
+	visible := 0
+	for _, s := range samples {
+		if s.visible {
+			visible++
+		}
+	}
+
+	report := []string{
+		"From: " + from.name,
+		"To: " + to.name,
+		fmt.Sprintf("Samples: %d, in sight: %d", len(samples), visible),
+	}
+	intervals := accessIntervals(samples)
+	if len(intervals) == 0 {
+		report = append(report, "No access")
+	}
+	for i, r := range intervals {
+		first, last := samples[r[0]], samples[r[1]]
+		if first.when.IsZero() {
+			report = append(report, fmt.Sprintf("Access %d: samples %d to %d", i+1, r[0]+1, r[1]+1))
+		} else {
+			report = append(report, fmt.Sprintf("Access %d: %s to %s (%s)", i+1,
+				first.when.Format(time.RFC3339), last.when.Format(time.RFC3339), last.when.Sub(first.when)))
+		}
+	}
+
+	return losResult{
+		name:    fmt.Sprintf("Line of sight (%s - %s)", from.name, to.name),
+		report:  report,
+		samples: samples,
+	}, nil
+}
+
+// returns the report of a line of sight as an HTML list, for the details pane
+func (r *losResult) description() string {
+	return reportHTML(r.report)
+}
+
+// returns the lines of a report as an HTML list
+func reportHTML(report []string) string {
+	items := []string{}
+	for _, line := range report {
+		items = append(items, "<li>"+html.EscapeString(line)+"</li>")
+	}
+	return "<ul>\n" + strings.Join(items, "\n") + "\n</ul>"
+}
+
+// returns the line vertices of a line of sight, one segment per sample, in sight or blocked colors
+func (r *losResult) vertices() []float32 {
+	vertices := []float32{}
+	for _, s := range r.samples {
+		c := losBlockedColor
+		if s.visible {
+			c = losVisibleColor
+		}
+		vertices = append(vertices,
+			float32(s.from[0]/a), float32(s.from[1]/a), float32(s.from[2]/a), c[0], c[1], c[2],
+			float32(s.to[0]/a), float32(s.to[1]/a), float32(s.to[2]/a), c[0], c[1], c[2])
+	}
+	return vertices
+}
+
+// adds a computed line of sight: shows its report in the gui (or prints it before the gui runs, for
+// -losfrom and -losto) and draws it with the selected features
+func addLOSResult(r losResult) {
+	mutex.Lock()
+	losResults = append(losResults, r)
+	mutex.Unlock()
+	state.resetting = true
+
+	report(r.name, r.report...)
+}
+
+// removes all computed lines of sight and the marked first end
+func clearLOSResults() {
+	mutex.Lock()
+	losResults = nil
+	losFrom = nil
+	mutex.Unlock()
+	state.resetting = true
+}
+
+// returns the first feature named name in the document, depth first, with the frame of its coordinates
+func findFeature(name string) (Folder, string, bool) {
+	var find func(f Folder, frame string) (Folder, string, bool)
+	find = func(f Folder, frame string) (Folder, string, bool) {
+		frame = folderFrame(f, frame)
+		if f.Name == name {
+			return f, frame, true
+		}
+		for _, child := range f.Folders {
+			if found, frame, ok := find(child, frame); ok {
+				return found, frame, true
+			}
+		}
+		return Folder{}, "", false
+	}
+	for _, f := range kml.Folders {
+		if found, frame, ok := find(f, frameECEF); ok {
+			return found, frame, true
+		}
+	}
+	return Folder{}, "", false
+}
+
+// returns one end of a line of sight for a feature
+func newLOSEnd(f Folder, frame string) (*losEnd, error) {
+	positions, err := featurePositions(f, frame)
+	if err != nil {
+		return nil, err
+	}
+	return &losEnd{name: f.Name, positions: positions}, nil
+}
+
+// computes the line of sight between two features given by name, for the -losfrom and -losto flags
+func lineOfSightByName(fromName string, toName string) error {
+	ends := []*losEnd{}
+	for _, name := range []string{fromName, toName} {
+		f, frame, ok := findFeature(name)
+		if !ok {
+			return fmt.Errorf("no feature named %q", name)
+		}
+		end, err := newLOSEnd(f, frame)
+		if err != nil {
+			return err
+		}
+		ends = append(ends, end)
+	}
+	r, err := newLOSResult(ends[0], ends[1])
+	if err != nil {
+		return err
+	}
+	addLOSResult(r)
+	return nil
+}
+
+// marks the feature of a tree node as one end of a line of sight. the first call marks the first end,
+// the second computes the line of sight to the first
+func markLOSEnd(ref []string) {
+	f, ok := lookupFolder(ref)
+	if !ok {
+		return
+	}
+	end, err := newLOSEnd(f, featureFrame(ref))
+	if err != nil {
+		addWarning(fmt.Sprint("Line of sight: ", err))
+		return
+	}
+
+	mutex.Lock()
+	from := losFrom
+	if from == nil {
+		losFrom = end
+	} else {
+		losFrom = nil
+	}
+	mutex.Unlock()
+
+	if from == nil {
+		text := detailsText("Line of sight", "<p>From: "+html.EscapeString(f.Name)+"</p><p>Press l on a second feature.</p>")
+		app.QueueUpdateDraw(func() {
+			detailsView.SetText(text).ScrollToBeginning()
+		})
+		return
+	}
+	r, err := newLOSResult(from, end)
+	if err != nil {
+		addWarning(fmt.Sprint("Line of sight: ", err))
+		return
+	}
+	addLOSResult(r)
+}
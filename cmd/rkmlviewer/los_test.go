@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClearLineOfSight(t *testing.T) {
+	tests := []struct {
+		name string
+		p, q [3]float64
+		want bool
+	}{
+		{"ground to zenith", geodeticToECEF(0, 0, 0), geodeticToECEF(0, 0, 500000), true},
+		{"ground to a satellite 10 degrees away", geodeticToECEF(0, 0, 0), geodeticToECEF(10, 0, 500000), true},
+		{"ground to a satellite 100 degrees away", geodeticToECEF(0, 0, 0), geodeticToECEF(0, 100, 500000), false},
+		{"opposite sides of the earth", geodeticToECEF(0, 0, 1000), geodeticToECEF(0, 180, 1000), false},
+		{"ground points 1 km apart", geodeticToECEF(0, 0, 0), geodeticToECEF(0, 0.009, 0), true},
+		{"ground points 100 km apart", geodeticToECEF(0, 0, 0), geodeticToECEF(0, 0.9, 0), false},
+		{"satellites above the horizon", geodeticToECEF(0, 0, 20000000), geodeticToECEF(0, 90, 20000000), true},
+		// a sphere of the equatorial radius would block this line, the ellipsoid doesn't
+		{"over the pole", [3]float64{-100000, 0, polarRadius + 100}, [3]float64{100000, 0, polarRadius + 100}, true},
+		{"same point", geodeticToECEF(45, 45, 0), geodeticToECEF(45, 45, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clearLineOfSight(tt.p, tt.q); got != tt.want {
+				t.Errorf("clearLineOfSight(%v, %v) = %v, want %v", tt.p, tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositionAt(t *testing.T) {
+	t0 := time.Date(2021, 3, 20, 9, 0, 0, 0, time.UTC)
+	track := []timedPosition{
+		{t0, [3]float64{0, 0, 0}},
+		{t0.Add(10 * time.Second), [3]float64{10, 20, 30}},
+		{t0.Add(20 * time.Second), [3]float64{10, 20, 40}},
+	}
+	tests := []struct {
+		name   string
+		at     time.Time
+		want   [3]float64
+		wantOK bool
+	}{
+		{"before the track", t0.Add(-time.Second), [3]float64{}, false},
+		{"first sample", t0, [3]float64{0, 0, 0}, true},
+		{"between samples", t0.Add(5 * time.Second), [3]float64{5, 10, 15}, true},
+		{"middle sample", t0.Add(10 * time.Second), [3]float64{10, 20, 30}, true},
+		{"between later samples", t0.Add(15 * time.Second), [3]float64{10, 20, 35}, true},
+		{"last sample", t0.Add(20 * time.Second), [3]float64{10, 20, 40}, true},
+		{"after the track", t0.Add(21 * time.Second), [3]float64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := positionAt(track, tt.at)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("positionAt(%v) = %v, %v, want %v, %v", tt.at, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAccessIntervals(t *testing.T) {
+	tests := []struct {
+		name    string
+		visible []bool
+		want    [][2]int
+	}{
+		{"no samples", nil, [][2]int{}},
+		{"never in sight", []bool{false, false}, [][2]int{}},
+		{"always in sight", []bool{true, true, true}, [][2]int{{0, 2}}},
+		{"several accesses", []bool{false, true, true, false, true}, [][2]int{{1, 2}, {4, 4}}},
+		{"in sight at both ends", []bool{true, false, false, true, true}, [][2]int{{0, 0}, {3, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := []losSample{}
+			for _, v := range tt.visible {
+				samples = append(samples, losSample{visible: v})
+			}
+			if got := accessIntervals(samples); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("accessIntervals(%v) = %v, want %v", tt.visible, got, tt.want)
+			}
+		})
+	}
+}
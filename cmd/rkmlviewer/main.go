@@ -1,15 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-gl/gl/v3.2-core/gl" // OR: github.com/go-gl/gl/v2.1/gl
 	"github.com/go-gl/glfw/v3.2/glfw"
@@ -18,23 +16,48 @@ import (
 
 const (
 	// options controlling size of earth
-	radius = 1.0       // radius of earth model
-	a      = 6378137.0 // earth radius in m
-	//rf     = 298.257223563 // wgs-84 flattening factor (not used)
+	radius      = 1.0             // equatorial radius of earth model
+	a           = 6378137.0       // wgs-84 equatorial radius in m
+	rf          = 298.257223563   // wgs-84 inverse flattening
+	polarRadius = a * (1 - 1/rf)  // wgs-84 polar radius in m
+	e2          = (2 - 1/rf) / rf // wgs-84 first eccentricity squared
+
+	// asset paths, relative to the asset directory (see assets.go)
 
 	// texture paths for earth model
-	diffusePath  = "../../assets/textures/earth2.jpg"
-	specularPath = "../../assets/textures/spec_map.png"
-	borderPath   = "../../assets/textures/night_lights.png"
-	cloudPath    = "../../assets/textures/clouds.jpg"
+	diffusePath  = "textures/earth2.jpg"
+	specularPath = "textures/spec_map.png"
+	borderPath   = "textures/night_lights.png"
+	cloudPath    = "textures/clouds.jpg"
+	fontPath     = "textures/font_7x13.png"
+
+	// graticule and boundaries
+	coastlinesPath      = "data/coastlines.geojson"
+	bordersPath         = "data/borders.geojson"
+	boundaryTexturePath = "textures/boundaries_16k.png"
+
+	// bright star catalogue for the star field
+	starCatalogPath = "data/bright_stars.csv"
+
+	// icon drawn for IconStyle hrefs that can't be loaded
+	defaultIconPath = "textures/default_icon.png"
 
 	// shader paths for earth, objects, clouds
-	vertexShaderPath         = "../../assets/shaders/vertexshader.glslv"
-	fragmentShaderPath       = "../../assets/shaders/fragmentshader.glslf"
-	objectVertexShaderPath   = "../../assets/shaders/objectvertexshader.glslv"
-	objectFragmentShaderPath = "../../assets/shaders/objectfragmentshader.glslf"
-	cloudVertexShaderPath    = "../../assets/shaders/cloudvertexshader.glslv"
-	cloudFragmentShaderPath  = "../../assets/shaders/cloudfragmentshader.glslf"
+	vertexShaderPath         = "shaders/vertexshader.glslv"
+	fragmentShaderPath       = "shaders/fragmentshader.glslf"
+	objectVertexShaderPath   = "shaders/objectvertexshader.glslv"
+	objectFragmentShaderPath = "shaders/objectfragmentshader.glslf"
+	cloudVertexShaderPath    = "shaders/cloudvertexshader.glslv"
+	cloudFragmentShaderPath  = "shaders/cloudfragmentshader.glslf"
+	labelVertexShaderPath    = "shaders/labelvertexshader.glslv"
+	labelFragmentShaderPath  = "shaders/labelfragmentshader.glslf"
+	iconVertexShaderPath     = "shaders/iconvertexshader.glslv"
+	iconFragmentShaderPath   = "shaders/iconfragmentshader.glslf"
+
+	overlayFragmentShaderPath       = "shaders/overlayfragmentshader.glslf"
+	screenOverlayFragmentShaderPath = "shaders/screenoverlayfragmentshader.glslf"
+	skyVertexShaderPath             = "shaders/skyvertexshader.glslv"
+	skyFragmentShaderPath           = "shaders/skyfragmentshader.glslf"
 
 	cGreen = "\x1B[32m"
 	cNorm  = "\x1B[0m"
@@ -66,6 +89,20 @@ type GameState struct {
 	showLines          bool
 	showPoints         bool
 	showOrbits         bool
+	showLabels         bool
+	showOverlays       bool
+	showTerminator     bool
+	showSky            bool
+	showGraticule      bool
+	showCoastlines     bool
+	showBorders        bool
+	showBoundaryMask   bool
+	graticuleSpacing   float64
+	showCoverage       bool
+	showGroundTracks   bool
+	measuring          bool
+	coverageElevation  float64
+	siderealRotation   bool
 	enableAntialiasing bool
 	enableBlending     bool
 	fps                int
@@ -98,7 +135,7 @@ var (
 	// camera object
 	camera Camera
 
-	//position of "sun" light, ambient light strength
+	//position of "sun" light, computed from the simulation time every frame, ambient light strength
 	lightPos        = mgl32.Vec3{200.0, 50.0, 200.0}
 	ambientStrength = 0.2
 
@@ -108,6 +145,9 @@ var (
 	// angle for earth rotation
 	angleZ float32
 
+	// longitude in degrees added to every KML coordinate, for data written in a frame rotated about the pole
+	frameLonOffset = 0.0
+
 	// default values to show earth, lines, points, orbits, antialiasing, blending, resetting, inputting, fps
 	state GameState
 
@@ -136,6 +176,12 @@ func init() {
 	state.showPoints = true
 	state.showLines = true
 	state.showOrbits = true
+	state.showLabels = true
+	state.showOverlays = true
+	state.showSky = true
+	state.graticuleSpacing = 10
+	state.showCoverage = true
+	state.coverageElevation = 10
 
 	state.resetting = true
 	state.inputting = false
@@ -147,6 +193,7 @@ func init() {
 func main() {
 	// define all flag values
 	filePath := flag.String("file", visualOutputPath, "/path/to/kml")
+	assetsF := flag.String("assets", "", "/path/to/assets, searched before the default locations")
 	widthF := flag.Int("width", width, "width")
 	heightF := flag.Int("height", height, "height")
 	fovF := flag.Int("fov", camera.fov, "field of view")
@@ -155,7 +202,23 @@ func main() {
 	samplesF := flag.Int("samples", samples, "number of MSAA samples")
 	ambientF := flag.Float64("ambient", ambientStrength, "strength of ambient lighting")
 	alphaF := flag.Float64("alpha", alpha, "line transparency")
-	gridF := flag.Bool("grid", false, "generate grid")
+	gridF := flag.Bool("grid", false, "draw the latitude/longitude graticule")
+	gridSpacingF := flag.Float64("gridspacing", state.graticuleSpacing, "graticule spacing in degrees")
+	timeF := flag.String("time", "", "simulation start time, UTC in RFC 3339 format (default: now)")
+	siderealF := flag.Bool("sidereal", false, "rotate the earth by sidereal time")
+	frameOffsetF := flag.Float64("frameoffset", frameLonOffset, "longitude offset in degrees applied to all kml coordinates")
+	tilesF := flag.String("tiles", "", "/path/to/tiles, an XYZ tile directory or .mbtiles file drawn over the earth texture")
+	tmsF := flag.Bool("tms", false, "rows of the tile directory are numbered from the south (TMS)")
+	demF := flag.String("dem", "", "/path/to/elevation, an SRTM .hgt or GeoTIFF file or a directory of them")
+	exaggerationF := flag.Float64("exaggeration", terrainExaggeration, "vertical exaggeration of the terrain")
+	filterF := flag.String("filter", "trilinear", "texture minification filter, bilinear or trilinear")
+	anisotropyF := flag.Float64("anisotropy", float64(textureAnisotropy), "anisotropic texture filtering, 1 to turn it off")
+	srgbF := flag.Bool("srgb", srgbTextures, "store color textures as sRGB and filter them in linear light")
+	compressF := flag.Bool("compress", compressTextures, "upload textures wider than 8192 pixels compressed")
+	groundTracksF := flag.Bool("groundtracks", false, "draw the ground tracks of tracks")
+	tickIntervalF := flag.Duration("tickinterval", groundTickInterval, "time between the ticks on ground tracks")
+	losFromF := flag.String("losfrom", "", "name of the feature a line of sight is computed from")
+	losToF := flag.String("losto", "", "name of the feature a line of sight is computed to")
 
 	// parse flags
 	fmt.Println("Parsing flags...")
@@ -179,7 +242,87 @@ func main() {
 	pointSize = *psF
 	samples = *samplesF
 
-	grid := *gridF
+	state.showGraticule = *gridF
+	if *gridSpacingF <= 0 || *gridSpacingF > 90 {
+		log.Fatalln("Invalid -gridspacing:", *gridSpacingF)
+	}
+	state.graticuleSpacing = *gridSpacingF
+	state.showGroundTracks = *groundTracksF
+	groundTickInterval = *tickIntervalF
+
+	config, err := loadConfig()
+	if err != nil {
+		addWarning(fmt.Sprint("Config file could not be read: ", err))
+	}
+	setAssetSearchPath(*assetsF, config)
+	frameLonOffset = *frameOffsetF
+	terrainExaggeration = *exaggerationF
+	filter, ok := textureFilters[*filterF]
+	if !ok {
+		log.Fatalln("Invalid -filter:", *filterF)
+	}
+	textureFilter = filter
+	textureAnisotropy = float32(*anisotropyF)
+	srgbTextures = *srgbF
+	compressTextures = *compressF
+	state.siderealRotation = *siderealF
+	if *timeF != "" {
+		t, err := time.Parse(time.RFC3339, *timeF)
+		if err != nil {
+			log.Fatalln("Invalid -time:", err)
+		}
+		simTime = t.UTC()
+	}
+
+	// terrain heights are needed to place features clamped to the ground
+	demPath := *demF
+	if demPath == "" {
+		demPath = config.DEM
+	}
+	if demPath != "" {
+		fmt.Println("Loading terrain...")
+		if err := loadTerrain(demPath); err != nil {
+			addWarning(fmt.Sprint("Terrain disabled: ", err))
+		}
+		fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+	}
+
+	// read the kml document and start from its view, if it has one
+	fmt.Println("Reading KML...")
+	kml = readKML(visualOutputPath, 0)
+	if len(kml.Folders) > 0 {
+		if pose, ok := featureView(kml.Folders[0]); ok {
+			setPose(pose)
+		}
+	}
+	tourPlayer.tours = collectTours(kml)
+	collectStyles(kml)
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	if *losFromF != "" || *losToF != "" {
+		fmt.Println("Computing line of sight...")
+		if err := lineOfSightByName(*losFromF, *losToF); err != nil {
+			addWarning(fmt.Sprint("Line of sight: ", err))
+		}
+		fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+	}
+
+	fmt.Println("Loading star catalogue...")
+	stars, err := loadStars(starCatalogPath)
+	if err != nil {
+		addWarning(fmt.Sprint("Star catalogue could not be loaded: ", err))
+	}
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	fmt.Println("Loading coastlines and borders...")
+	loadMapLines()
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	fmt.Println("Loading bookmarks...")
+	if err := loadBookmarks(); err != nil {
+		fmt.Println("Error: Bookmarks could not be loaded:", err)
+	}
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// initiate glfw and OpenGL
 	fmt.Println("Initializing GLFW...")
@@ -188,6 +331,7 @@ func main() {
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 	fmt.Println("Initializing OpenGL...")
 	initOpenGL()
+	initTextureFiltering()
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// create the shader programs for each class of objects
@@ -195,6 +339,11 @@ func main() {
 	globeProgram := newProgram(vertexShaderPath, fragmentShaderPath)
 	objectProgram := newProgram(objectVertexShaderPath, objectFragmentShaderPath)
 	cloudProgram := newProgram(cloudVertexShaderPath, cloudFragmentShaderPath)
+	labelProgram := newProgram(labelVertexShaderPath, labelFragmentShaderPath)
+	iconProgram := newProgram(iconVertexShaderPath, iconFragmentShaderPath)
+	overlayProgram := newProgram(vertexShaderPath, overlayFragmentShaderPath)
+	screenOverlayProgram := newProgram(labelVertexShaderPath, screenOverlayFragmentShaderPath)
+	skyProgram := newProgram(skyVertexShaderPath, skyFragmentShaderPath)
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// define vertices for the default axis
@@ -216,18 +365,18 @@ func main() {
 		0.0, -20.0, 0.0, 0.2, 0.8, 0.0,
 	}
 
-	if grid {
-		axis = append(axis, genGrid(30, 30, -5, 5, 5, -5)...)
-	}
-
 	lineStart := len(axis) / 6
 
 	// init main vertex array
 	objectVertices := axis
 
+	// icon vertices are kept apart from the other objects since they have a different layout
+	iconVertices := []float32{}
+
 	// define variables to store locations of different types of data in the vertex array
 	pointStart := len(objectVertices)
 	orbitStart := len(objectVertices)
+	groundStart := len(objectVertices)
 
 	// generate two spheres, one for the globe and one for the clouds
 	fmt.Println("Generating sphere vertices...")
@@ -237,91 +386,207 @@ func main() {
 
 	fmt.Println("Generating vertex array objects...")
 	// generate a vertex array object to store the object data
-	lineVertexArray := makeVaoColoredLines(objectVertices, nil, 6*4)
+	lineVertexArray, lineVertexBuffer := makeVaoColoredLines(objectVertices, nil, 6*4)
 
 	// generate two vertex array objects for the earth and the clouds
 	earthVertexArray := makeVaoEarth(earthVertices, earthIndices, 8*4)
 	cloudVertexArray := makeVaoEarth(cloudVertices, cloudIndices, 8*4)
+
+	// generate a vertex array object for the labels, filled every frame
+	labelVertexArray, labelVertexBuffer := makeVaoLabels(labelStride * 4)
+	iconVertexArray, iconVertexBuffer := makeVaoIcons(iconVertices, iconStride*4)
+
+	// the sky uses the icon vertex layout and is filled every frame
+	skyVertexArray, skyVertexBuffer := makeVaoIcons(nil, iconStride*4)
+
+	// the graticule, coastlines and borders have their own vertex array, rebuilt when the spacing changes
+	var maps mapLines
+	maps.build(state.graticuleSpacing)
+
+	// the coverage cones have their own vertex array, built with the selection and rebuilt as they move
+	var cones coverage
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// generate necessary textures for the earth and clouds
 	fmt.Println("Generating textures...")
-	texture := generateTexture(diffusePath)
-	texture2 := generateTexture(borderPath)
-	specMap := generateTexture(specularPath)
-	cloudTexture := generateTexture(cloudPath)
+	// missing or broken textures are replaced: the diffuse texture by land and ocean colors,
+	// the others by black, which means no night lights, no specular highlights and no clouds.
+	// the night lights and the specular map are used as masks, so they are never stored as sRGB
+	texture, err := generateTexture(diffusePath, colorRGB)
+	if err != nil {
+		addWarning(fmt.Sprint("Earth texture replaced by land/ocean colors: ", err))
+		pixels, x, y := landOceanPixels(specularPath)
+		texture = textureFromPixels(pixels, x, y, colorRGB)
+	}
+	texture2, err := generateTexture(borderPath, dataRGB)
+	if err != nil {
+		addWarning(fmt.Sprint("Night lights disabled: ", err))
+		texture2 = textureFromPixels(solidPixels([4]uint8{0, 0, 0, 255}), 1, 1, dataRGB)
+	}
+	specMap, err := generateTexture(specularPath, dataRGB)
+	if err != nil {
+		addWarning(fmt.Sprint("Specular highlights disabled: ", err))
+		specMap = textureFromPixels(solidPixels([4]uint8{0, 0, 0, 255}), 1, 1, dataRGB)
+	}
+	cloudTexture, err := generateTexture(cloudPath, colorRGB)
+	if err != nil {
+		addWarning(fmt.Sprint("Clouds disabled: ", err))
+		cloudTexture = textureFromPixels(solidPixels([4]uint8{0, 0, 0, 255}), 1, 1, dataRGB)
+	}
+
+	// the font is drawn at its native size, so sample it without filtering
+	fontTexture, err := generateTexture(fontPath, dataRGB)
+	if err != nil {
+		addWarning(fmt.Sprint("Labels disabled: ", err))
+		fontTexture = textureFromPixels(solidPixels([4]uint8{0, 0, 0, 255}), 1, 1, dataRGB)
+	}
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+
+	// tiled imagery replaces the earth texture where it has tiles, terrain is drawn on the same tiles
+	tilesPath := *tilesF
+	if tilesPath == "" {
+		tilesPath = config.Tiles
+	}
+	if tilesPath != "" {
+		source, err := openTileSource(tilesPath, *tmsF)
+		if err != nil {
+			addWarning(fmt.Sprint("Tiled imagery disabled: ", err))
+		} else {
+			globeTiles = newTileLayer(source)
+		}
+	}
+	if globeTiles == nil && len(demGrids) > 0 {
+		globeTiles = newTileLayer(nil)
+	}
+
+	// pack every IconStyle icon into one texture so all icons are drawn at once
+	iconAtlas := buildIconAtlas(collectIconHrefs(kml))
+
+	// ground overlays get their own patch of sphere, screen overlays a quad in window pixels
+	groundOverlays, screenOverlays := loadOverlays(kml)
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// ################## SETUP GLOBE UNIFORMS ##################
 	fmt.Println("Setting up globe uniform variables...")
-	gl.UseProgram(globeProgram)
+	globeProgram.use()
 
 	// setup scene projection
 	projection := mgl32.Perspective(mgl32.DegToRad(float32(camera.fov)), float32(width)/float32(height), 0.01, 100.0)
-	_ = setUniform(globeProgram, projection, "projection")
+	globeProgram.set("projection", projection)
 
 	// setup camera
 	cameraMat := mgl32.LookAtV(camera.Pos, camera.Front, camera.Up)
-	globeCameraUniform := setUniform(globeProgram, cameraMat, "camera")
+	globeProgram.set("camera", cameraMat)
 
 	// rotate earth to correct orientation
 	model := mgl32.HomogRotate3D(mgl32.DegToRad(-90.0), mgl32.Vec3{1, 0, 0})
-	globeModelUniform := setUniform(globeProgram, model, "model")
+	globeProgram.set("model", model)
 
 	// define color of earth, ambient light strength, atmosphere color, light color, light position
-	_ = setUniform(globeProgram, objectColor, "objectColor")
-	_ = setUniform(globeProgram, ambientStrength, "ambientStrength")
-	_ = setUniform(globeProgram, atmoColor, "atmoColor")
-	_ = setUniform(globeProgram, atmoColor2, "atmoColor2")
-	_ = setUniform(globeProgram, lightColor, "lightColor")
-	_ = setUniform(globeProgram, lightPos, "lightPos")
+	globeProgram.set("objectColor", objectColor)
+	globeProgram.set("ambientStrength", ambientStrength)
+	globeProgram.set("atmoColor", atmoColor)
+	globeProgram.set("atmoColor2", atmoColor2)
+	globeProgram.set("lightColor", lightColor)
+	globeProgram.set("lightPos", lightPos)
 
 	// set earth textures
-	gl.Uniform1i(gl.GetUniformLocation(globeProgram, gl.Str("ourTexture2\x00")), 0)
-	gl.Uniform1i(gl.GetUniformLocation(globeProgram, gl.Str("specMap\x00")), 2)
-	gl.Uniform1i(gl.GetUniformLocation(globeProgram, gl.Str("ourTexture\x00")), 1)
+	globeProgram.set("ourTexture2", 0)
+	globeProgram.set("specMap", 2)
+	globeProgram.set("ourTexture", 1)
+	globeProgram.set("boundaryTexture", 7)
+	globeProgram.set("boundaryColor", borderColor)
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// view position
-	globeViewPosUniform := setUniform(globeProgram, camera.Pos, "viewPos")
+	globeProgram.set("viewPos", camera.Pos)
 
 	// ################## SETUP OBJECT UNIFORMS ##################
 	fmt.Println("Setting up object uniform variables...")
-	gl.UseProgram(objectProgram)
+	objectProgram.use()
 
 	// rotate objects same as earth
 	modelo := mgl32.HomogRotate3D(mgl32.DegToRad(float32(90)), mgl32.Vec3{1, 0, 0}).
 		Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(float32(180)), mgl32.Vec3{0, 1, 0})).
 		Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(float32(angleZ)), mgl32.Vec3{0, 0, 1}))
-	objectModelUniform := setUniform(objectProgram, modelo, "model")
+	objectProgram.set("model", modelo)
 
 	// set projection, alpha
-	_ = setUniform(objectProgram, projection, "projection")
-	_ = setUniform(objectProgram, alpha, "alpha")
+	objectProgram.set("projection", projection)
+	objectProgram.set("alpha", alpha)
 
 	// view position
-	objectCameraUniform := setUniform(objectProgram, cameraMat, "camera")
+	objectProgram.set("camera", cameraMat)
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	// ################## SETUP CLOUD UNIFORMS ##################
 	fmt.Println("Setting up cloud uniform variables...")
-	gl.UseProgram(cloudProgram)
+	cloudProgram.use()
 
 	//set cloud texture
-	gl.Uniform1i(gl.GetUniformLocation(cloudProgram, gl.Str("cloudTexture\x00")), 3)
+	cloudProgram.set("cloudTexture", 3)
 
 	// rotate clouds to correct orientation
 	modelc := mgl32.HomogRotate3D(mgl32.DegToRad(-90.0), mgl32.Vec3{1, 0, 0})
-	cloudModelUniform := setUniform(cloudProgram, modelc, "model")
+	cloudProgram.set("model", modelc)
 
 	// set projection, ambient, lightpos
-	_ = setUniform(cloudProgram, projection, "projection")
-	_ = setUniform(cloudProgram, ambientStrength, "ambientStrength")
-	_ = setUniform(cloudProgram, lightPos, "lightPos")
+	cloudProgram.set("projection", projection)
+	cloudProgram.set("ambientStrength", ambientStrength)
+	cloudProgram.set("lightPos", lightPos)
 
 	//setup camera, view position
-	cloudCameraUniform := setUniform(cloudProgram, cameraMat, "camera")
-	cloudViewPosUniform := setUniform(cloudProgram, camera.Pos, "viewPos")
+	cloudProgram.set("camera", cameraMat)
+	cloudProgram.set("viewPos", camera.Pos)
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	// ################## SETUP ICON UNIFORMS ##################
+	fmt.Println("Setting up icon uniform variables...")
+	iconProgram.use()
+
+	// icons are placed like the other objects
+	iconProgram.set("projection", projection)
+	iconProgram.set("model", model)
+	iconProgram.set("camera", cameraMat)
+	iconProgram.set("cellSize", 1.0/float64(iconAtlasColumns))
+	iconProgram.set("iconTexture", 5)
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	// ################## SETUP LABEL UNIFORMS ##################
+	fmt.Println("Setting up label uniform variables...")
+	labelProgram.use()
+
+	// labels are positioned in pixels from the top left of the window
+	labelProgram.set("projection", mgl32.Ortho(0, float32(width), float32(height), 0, -1, 1))
+	labelProgram.set("fontTexture", 4)
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	// ################## SETUP SKY UNIFORMS ##################
+	fmt.Println("Setting up sky uniform variables...")
+	skyProgram.use()
+
+	// the sky is drawn with only the camera rotation
+	skyProgram.set("projection", projection)
+	skyProgram.set("camera", cameraMat)
+	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
+
+	// ################## SETUP OVERLAY UNIFORMS ##################
+	fmt.Println("Setting up overlay uniform variables...")
+	overlayProgram.use()
+
+	// ground overlays are placed and lit like the globe
+	overlayProgram.set("projection", projection)
+	overlayProgram.set("ambientStrength", ambientStrength)
+	overlayProgram.set("lightPos", lightPos)
+	overlayProgram.set("model", model)
+	overlayProgram.set("camera", cameraMat)
+	overlayProgram.set("overlayTexture", 6)
+
+	// screen overlays are positioned in pixels like the labels
+	screenOverlayProgram.use()
+	screenOverlayProgram.set("projection", mgl32.Ortho(0, float32(width), float32(height), 0, -1, 1))
+	screenOverlayProgram.set("overlayTexture", 6)
 	fmt.Printf("%s[DONE]%s\n", cGreen, cNorm)
 
 	//################## SETUP GLOBAL OPENGL OPTIONS ##################
@@ -352,6 +617,10 @@ func main() {
 	lastTime := glfw.GetTime()
 	nbFrames := 0
 
+	// cursor position and camera at the last pick, picking is redone when either changes
+	var lastCursorX, lastCursorY float64
+	var lastPickMat mgl32.Mat4
+
 	fmt.Println("Starting GUI on separate thread...")
 	//start the gui in a separate goroutine
 	wg := sync.WaitGroup{}
@@ -371,11 +640,25 @@ func main() {
 			objectVertices = axis
 			kmlVertices := []float32{}
 
-			kmlVertices, pointStart, orbitStart = interpretSelected()
+			kmlVertices, iconVertices, pointStart, orbitStart, groundStart = interpretSelected()
 			objectVertices = append(objectVertices, kmlVertices...)
 
 			// generate vertex array for line/point object
-			lineVertexArray = makeVaoColoredLines(objectVertices, nil, 6*4)
+			lineVertexArray, lineVertexBuffer = makeVaoColoredLines(objectVertices, nil, 6*4)
+			iconVertexArray, iconVertexBuffer = makeVaoIcons(iconVertices, iconStride*4)
+
+			// keep the positions of inertial features as built, they are turned with the earth every frame
+			inertialVertices = append([]float32{}, kmlVertices...)
+			inertialIcons = append([]float32{}, iconVertices...)
+			inertialAngle = math.NaN()
+
+			// cones of the new selection
+			cones.build()
+
+			// the old pick refers to the previous selection
+			picked = -1
+			lastPickMat = mgl32.Mat4{}
+			showPicked(nil)
 
 			state.resetting = false
 		}
@@ -402,19 +685,73 @@ func main() {
 			lastTime += 1.0
 		}
 
+		// rebuild shaders edited on disk, a broken one keeps the previous program
+		reloadShaders(currentFrame)
+
 		processInput(win, deltaTime)
+		recallQueuedBookmark()
+		updateTour(deltaTime)
+		flyToQueued()
+		updateFlight(deltaTime)
+		advanceSimTime(deltaTime)
+
+		// turn inertial features back by the earth rotation so they stay fixed in space
+		if updateInertial(objectVertices[lineStart*6:], iconVertices) {
+			gl.BindBuffer(gl.ARRAY_BUFFER, lineVertexBuffer)
+			gl.BufferSubData(gl.ARRAY_BUFFER, lineStart*6*4, 4*(len(objectVertices)-lineStart*6), gl.Ptr(objectVertices[lineStart*6:]))
+			if len(iconVertices) > 0 {
+				gl.BindBuffer(gl.ARRAY_BUFFER, iconVertexBuffer)
+				gl.BufferSubData(gl.ARRAY_BUFFER, 0, 4*len(iconVertices), gl.Ptr(iconVertices))
+			}
+			gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+		}
+
 		//update matrices
 		cameraMat = mgl32.LookAtV(camera.Pos, camera.Pos.Add(camera.Front), camera.Up)
-		model = mgl32.HomogRotate3D(mgl32.DegToRad(-90.0), mgl32.Vec3{1, 0, 0}).
-			Mul4(mgl32.HomogRotate3D(float32(mgl32.DegToRad(float32(angleZ))), mgl32.Vec3{0, 0, 1}))
+		model = earthModel()
+		lightPos = sunPosition(model)
+
+		// pick the feature under the cursor while the mouse is unlocked
+		if !state.inputting {
+			x, y := win.GetCursorPos()
+			pickMat := projection.Mul4(cameraMat).Mul4(model)
+			if x != lastCursorX || y != lastCursorY || pickMat != lastPickMat {
+				lastCursorX, lastCursorY, lastPickMat = x, y, pickMat
+				if p := pickFeature(objectVertices[lineStart*6:], iconVertices, pickMat, model, camera.Pos, x, y); p != picked {
+					picked = p
+					if p >= 0 {
+						showPicked(&pickFeatures[p])
+					} else {
+						showPicked(nil)
+					}
+				}
+			}
+		}
+
+		// measure at a click in measurement mode, leaving the mode drops a half finished measurement
+		if x, y, ok := takeMeasureClick(); ok && state.measuring {
+			measureAt(x, y, objectVertices[lineStart*6:], iconVertices, projection.Mul4(cameraMat).Mul4(model))
+		}
+		if !state.measuring {
+			measureFrom = nil
+		}
+
+		//render stars, sun and moon
+		if state.showSky {
+			skyProgram.use()
+			skyMat := mgl32.LookAtV(mgl32.Vec3{}, camera.Front, camera.Up)
+			skyProgram.set("camera", skyMat)
+			drawSky(skyProgram, skyVertexArray, skyVertexBuffer, buildSky(stars, model))
+		}
 
 		//render globe
 		if state.showEarth {
-			gl.UseProgram(globeProgram)
-			gl.UniformMatrix4fv(globeModelUniform, 1, false, &model[0])
-			gl.UniformMatrix4fv(globeCameraUniform, 1, false, &cameraMat[0])
-			gl.Uniform3fv(globeViewPosUniform, 1, &camera.Pos[0])
-			//gl.Uniform3fv(lightPosUniform, 1, &lightPos[0])
+			globeProgram.use()
+			globeProgram.set("model", globeModel(model))
+			globeProgram.set("camera", cameraMat)
+			globeProgram.set("viewPos", camera.Pos)
+			globeProgram.set("lightPos", lightPos)
+			globeProgram.set("showTerminator", state.showTerminator)
 
 			gl.ActiveTexture(gl.TEXTURE0)
 			gl.BindTexture(gl.TEXTURE_2D, texture2)
@@ -425,16 +762,45 @@ func main() {
 			gl.ActiveTexture(gl.TEXTURE2)
 			gl.BindTexture(gl.TEXTURE_2D, specMap)
 
+			globeProgram.set("showBoundaries", state.showBoundaryMask && bindBoundaryTexture())
+
 			gl.BindVertexArray(earthVertexArray)
 
 			gl.DrawElements(gl.TRIANGLES, int32((stackCount*stackCount-2)*sectorCount), gl.UNSIGNED_INT, gl.PtrOffset(0))
+
+			drawTiles(globeProgram, model)
+		}
+
+		//render ground overlays
+		if state.showOverlays {
+			overlayProgram.use()
+			overlayProgram.set("model", model)
+			overlayProgram.set("camera", cameraMat)
+			overlayProgram.set("lightPos", lightPos)
+			drawGroundOverlays(overlayProgram, groundOverlays)
+		}
+
+		//render graticule, coastlines and borders
+		if state.showGraticule || state.showCoastlines || state.showBorders {
+			objectProgram.use()
+			objectProgram.set("camera", cameraMat)
+			objectProgram.set("model", model)
+			maps.draw(objectProgram)
+		}
+
+		//render coverage cones and footprints
+		if state.showCoverage {
+			objectProgram.use()
+			objectProgram.set("camera", cameraMat)
+			objectProgram.set("model", model)
+			cones.draw(objectProgram)
 		}
 
 		//render objects
-		if state.showLines || state.showPoints || state.showOrbits {
-			gl.UseProgram(objectProgram)
-			gl.UniformMatrix4fv(objectCameraUniform, 1, false, &cameraMat[0])
-			gl.UniformMatrix4fv(objectModelUniform, 1, false, &model[0])
+		if state.showLines || state.showPoints || state.showOrbits || state.showGroundTracks {
+			objectProgram.use()
+			objectProgram.set("camera", cameraMat)
+			objectProgram.set("model", model)
 			gl.BindVertexArray(lineVertexArray)
 			// //gl.DrawArrays(gl.LINES, 0, int32(len(vertices)))
 			if state.showLines {
@@ -449,7 +815,20 @@ func main() {
 			//gl.BindVertexArray(orbitVertexArray)
 			if state.showOrbits {
 				// 	//gl.DrawElements(gl.LINES, int32(len(orbitVertices)/6), gl.UNSIGNED_INT, gl.PtrOffset(0))
-				gl.DrawArrays(gl.LINES, int32(orbitStart/6+lineStart), int32((len(objectVertices)-orbitStart)/6))
+				gl.DrawArrays(gl.LINES, int32(orbitStart/6+lineStart), int32((groundStart-orbitStart)/6))
+			}
+
+			if state.showGroundTracks {
+				gl.DrawArrays(gl.LINES, int32(groundStart/6+lineStart), int32(len(objectVertices)/6-lineStart-groundStart/6))
+			}
+
+			drawHighlight(objectProgram, int32(lineStart))
+
+			if state.showPoints {
+				iconProgram.use()
+				iconProgram.set("camera", cameraMat)
+				iconProgram.set("model", model)
+				drawIcons(iconProgram, iconVertexArray, iconAtlas, int32(len(iconVertices)/iconStride))
 			}
 		}
 
@@ -458,10 +837,11 @@ func main() {
 		if state.enableBlending && state.showEarth {
 			gl.Enable(gl.BLEND)
 
-			gl.UseProgram(cloudProgram)
-			gl.UniformMatrix4fv(cloudCameraUniform, 1, false, &cameraMat[0])
-			gl.UniformMatrix4fv(cloudModelUniform, 1, false, &model[0])
-			gl.Uniform3fv(cloudViewPosUniform, 1, &camera.Pos[0])
+			cloudProgram.use()
+			cloudProgram.set("camera", cameraMat)
+			cloudProgram.set("model", model)
+			cloudProgram.set("viewPos", camera.Pos)
+			cloudProgram.set("lightPos", lightPos)
 
 			gl.ActiveTexture(gl.TEXTURE3)
 			gl.BindTexture(gl.TEXTURE_2D, cloudTexture)
@@ -473,6 +853,18 @@ func main() {
 			gl.Disable(gl.BLEND)
 		}
 
+		//render labels
+		if state.showLabels {
+			mvp := projection.Mul4(cameraMat).Mul4(model)
+			labelVertices := buildLabels(objectVertices[lineStart*6:], iconVertices, mvp, model, camera.Pos)
+			drawLabels(labelProgram, labelVertexArray, labelVertexBuffer, fontTexture, labelVertices)
+		}
+
+		//render screen overlays
+		if state.showOverlays {
+			drawScreenOverlays(screenOverlayProgram, screenOverlays)
+		}
+
 		//collision detection for earth
 		d := math.Sqrt(math.Pow(float64(camera.Pos[0]), 2) + math.Pow(float64(camera.Pos[1]), 2) + math.Pow(float64(camera.Pos[2]), 2))
 		if d < radius+(float64(moveSpeed)/111+0.02) {
@@ -483,20 +875,6 @@ func main() {
 	}
 }
 
-// sets a uniform value in the given shader program. supports mat4, vec3, float
-func setUniform(program uint32, value interface{}, id string) int32 {
-	uniform := gl.GetUniformLocation(program, gl.Str(id+"\x00"))
-	switch v := value.(type) {
-	case mgl32.Mat4:
-		gl.UniformMatrix4fv(uniform, 1, false, &v[0])
-	case mgl32.Vec3:
-		gl.Uniform3fv(uniform, 1, &v[0])
-	case float64:
-		gl.Uniform1f(uniform, float32(v))
-	}
-	return uniform
-}
-
 // initGlfw initializes glfw and returns a Window to use
 func initGlfw() *glfw.Window {
 	if err := glfw.Init(); err != nil {
@@ -508,6 +886,9 @@ func initGlfw() *glfw.Window {
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 	//glfw.WindowHint(glfw.Resizable, glfw.True)
 	glfw.WindowHint(glfw.Samples, samples)
+	if srgbTextures {
+		glfw.WindowHint(glfw.SRGBCapable, glfw.True)
+	}
 
 	window, err := glfw.CreateWindow(width, height, "rkmlviewer", nil, nil)
 	if err != nil {
@@ -537,82 +918,6 @@ func initOpenGL() {
 	log.Println("OpenGL version", version)
 }
 
-// opens and reads shader files, compiles them and returns program
-func newProgram(vertexShaderS string, fragmentShaderS string) uint32 {
-	v, err := os.Open(vertexShaderS)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer v.Close()
-
-	f, err := os.Open(fragmentShaderS)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	frag := ""
-	vert := ""
-
-	scannerV := bufio.NewScanner(v)
-	for scannerV.Scan() {
-		vert += "\n" + scannerV.Text()
-	}
-	if err := scannerV.Err(); err != nil {
-		log.Fatal(err)
-	}
-	vert += "\x00"
-
-	scannerF := bufio.NewScanner(f)
-	for scannerF.Scan() {
-		frag += "\n" + scannerF.Text()
-	}
-	if err := scannerF.Err(); err != nil {
-		log.Fatal(err)
-	}
-	frag += "\x00"
-
-	vertexShader, err := compileShader(vert, gl.VERTEX_SHADER)
-	if err != nil {
-		panic(err)
-	}
-
-	fragmentShader, err := compileShader(frag, gl.FRAGMENT_SHADER)
-	if err != nil {
-		panic(err)
-	}
-
-	prog := gl.CreateProgram()
-	gl.AttachShader(prog, vertexShader)
-	gl.AttachShader(prog, fragmentShader)
-	gl.LinkProgram(prog)
-	return prog
-}
-
-// compiles a shader
-func compileShader(source string, shaderType uint32) (uint32, error) {
-	shader := gl.CreateShader(shaderType)
-
-	csources, free := gl.Strs(source)
-	gl.ShaderSource(shader, 1, csources, nil)
-	free()
-	gl.CompileShader(shader)
-
-	var status int32
-	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
-	if status == gl.FALSE {
-		var logLength int32
-		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
-
-		log := strings.Repeat("\x00", int(logLength+1))
-		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
-
-		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
-	}
-
-	return shader, nil
-}
-
 // makeVao initializes and returns a vertex array from points, colors, texture coordinates
 func makeVaoEarth(vertices []float32, indices []uint32, stride int32) uint32 {
 	var vertexBuffer, elementBuffer, vertexArray uint32
@@ -646,8 +951,8 @@ func makeVaoEarth(vertices []float32, indices []uint32, stride int32) uint32 {
 	return vertexArray
 }
 
-// generates a vertex array with no texture coordinates
-func makeVaoColoredLines(vertices []float32, indices []uint32, stride int32) uint32 {
+// generates a vertex array with no texture coordinates, returns it with its vertex buffer so positions can be updated
+func makeVaoColoredLines(vertices []float32, indices []uint32, stride int32) (uint32, uint32) {
 	var vertexBuffer, elementBuffer, vertexArray uint32
 
 	gl.GenBuffers(1, &vertexBuffer)
@@ -673,60 +978,114 @@ func makeVaoColoredLines(vertices []float32, indices []uint32, stride int32) uin
 	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
 	gl.BindVertexArray(0)
 
-	return vertexArray
+	return vertexArray, vertexBuffer
 }
 
-//generates and returns and OpenGL texture object
-func generateTexture(path string) uint32 {
-	pixels, x, y := loadImage(path)
+// generates a vertex array for icon point sprites (position, tint, atlas cell, size), returns it with its vertex buffer
+func makeVaoIcons(vertices []float32, stride int32) (uint32, uint32) {
+	var vertexBuffer, vertexArray uint32
 
-	var texture uint32
-	gl.GenTextures(1, &texture)
-	gl.BindTexture(gl.TEXTURE_2D, texture)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-	gl.GenerateMipmap(gl.TEXTURE_2D)
+	gl.GenBuffers(1, &vertexBuffer)
+	gl.GenVertexArrays(1, &vertexArray)
+
+	gl.BindVertexArray(vertexArray)
 
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGB, x, y, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+	gl.BindBuffer(gl.ARRAY_BUFFER, vertexBuffer)
+	if len(vertices) > 0 {
+		gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.STATIC_DRAW)
+	}
 
-	return texture
+	gl.VertexAttribPointer(0, 3, gl.FLOAT, false, stride, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
+
+	gl.VertexAttribPointer(1, 4, gl.FLOAT, false, stride, gl.PtrOffset(3*4))
+	gl.EnableVertexAttribArray(1)
+
+	gl.VertexAttribPointer(2, 2, gl.FLOAT, false, stride, gl.PtrOffset(7*4))
+	gl.EnableVertexAttribArray(2)
+
+	gl.VertexAttribPointer(3, 1, gl.FLOAT, false, stride, gl.PtrOffset(9*4))
+	gl.EnableVertexAttribArray(3)
+
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gl.BindVertexArray(0)
+
+	return vertexArray, vertexBuffer
 }
 
-func genGrid(nCols int, nRows int, x1 float64, y1 float64, x2 float64, y2 float64) []float32 {
-	vertices := []float32{}
+// generates a vertex array for screen space labels (position, texture coordinate, color),
+// returns it with its vertex buffer so the buffer can be refilled every frame
+func makeVaoLabels(stride int32) (uint32, uint32) {
+	var vertexBuffer, vertexArray uint32
+
+	gl.GenBuffers(1, &vertexBuffer)
+	gl.GenVertexArrays(1, &vertexArray)
+
+	gl.BindVertexArray(vertexArray)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vertexBuffer)
 
-	xStep := math.Abs(x2-x1) / float64(nCols-1)
-	yStep := math.Abs(y2-y1) / float64(nRows-1)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, stride, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(0)
 
-	for i := 0; i < nCols; i++ {
-		pos1X := x1 + float64(i)*xStep
-		pos1Y := y1
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, stride, gl.PtrOffset(2*4))
+	gl.EnableVertexAttribArray(1)
 
-		pos2X := x1 + float64(i)*xStep
-		pos2Y := y2
+	gl.VertexAttribPointer(2, 4, gl.FLOAT, false, stride, gl.PtrOffset(4*4))
+	gl.EnableVertexAttribArray(2)
 
-		pos1Z := 0.0
-		pos2Z := 0.0
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gl.BindVertexArray(0)
 
-		var r, g, b float32 = 1.0, 1.0, 1.0
+	return vertexArray, vertexBuffer
+}
 
-		vertices = append(vertices, float32(pos1X), float32(pos1Y), float32(pos1Z), r, g, b, float32(pos2X), float32(pos2Y), float32(pos2Z), r, g, b)
+//generates and returns and OpenGL texture object
+func generateTexture(path string, format textureFormat) (uint32, error) {
+	pixels, x, y, err := loadImage(path)
+	if err != nil {
+		return 0, err
 	}
 
-	for j := 0; j < nRows; j++ {
-		pos1X := x1
-		pos1Y := y1 - float64(j)*yStep
+	return textureFromPixels(pixels, x, y, format), nil
+}
 
-		pos2X := x2
-		pos2Y := -y2 - float64(j)*yStep
+// generates a mipmapped OpenGL texture object from RGBA pixels (gray pixels for dataGray). with -compress,
+// textures wider than compressWidth are stored compressed
+func textureFromPixels(pixels []uint8, x int32, y int32, format textureFormat) uint32 {
+	compressed := compressTextures && x > compressWidth
 
-		pos1Z := 0.0
-		pos2Z := 0.0
+	var texture uint32
+	gl.GenTextures(1, &texture)
+	gl.BindTexture(gl.TEXTURE_2D, texture)
+	if format == dataGray {
+		// rows of gray pixels aren't padded to 4 bytes
+		gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+		gl.TexImage2D(gl.TEXTURE_2D, 0, internalFormat(format, compressed), x, y, 0, gl.RED, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+		gl.PixelStorei(gl.UNPACK_ALIGNMENT, 4)
+	} else {
+		gl.TexImage2D(gl.TEXTURE_2D, 0, internalFormat(format, compressed), x, y, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+	}
 
-		var r, g, b float32 = 1.0, 1.0, 1.0
+	// the mipmaps are made from the uploaded image, so they can only be generated after it
+	gl.GenerateMipmap(gl.TEXTURE_2D)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, textureFilter)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	if textureAnisotropy > 1 && maxAnisotropy > 0 {
+		anisotropy := textureAnisotropy
+		if anisotropy > maxAnisotropy {
+			anisotropy = maxAnisotropy
+		}
+		gl.TexParameterf(gl.TEXTURE_2D, gl.TEXTURE_MAX_ANISOTROPY, anisotropy)
+	}
 
-		vertices = append(vertices, float32(pos1X), float32(pos1Y), float32(pos1Z), r, g, b, float32(pos2X), float32(pos2Y), float32(pos2Z), r, g, b)
+	// the generic compressed formats may be stored uncompressed if the driver has no suitable format
+	if compressed {
+		var isCompressed int32
+		gl.GetTexLevelParameteriv(gl.TEXTURE_2D, 0, gl.TEXTURE_COMPRESSED, &isCompressed)
+		if isCompressed == gl.FALSE {
+			addWarning(fmt.Sprintf("The graphics driver stored a %dx%d texture uncompressed", x, y))
+		}
 	}
 
-	return vertices
+	return texture
 }
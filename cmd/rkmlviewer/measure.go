@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+var (
+	// color of measurement lines
+	measureColor = [3]float32{1, 1, 1}
+
+	// click in the window waiting to be measured by the render loop
+	measureClick struct {
+		sync.Mutex
+		x, y    float64
+		pending bool
+	}
+
+	// first end of a measurement, nil until one is clicked. only used by the render loop
+	measureFrom *measurePoint
+
+	// completed measurements, drawn after the selected features. guarded by mutex
+	measurements []measurement
+)
+
+// measurePoint is a clicked feature or globe location
+type measurePoint struct {
+	name string
+	pos  [3]float64 // earth fixed, m
+}
+
+// measurement is the range and angles between two clicked points, drawn and picked like a feature
+type measurement struct {
+	name     string
+	report   []string
+	from, to [3]float64
+}
+
+// records a click in the window while measuring, it is measured at the next frame
+func queueMeasureClick(x float64, y float64) {
+	measureClick.Lock()
+	measureClick.x, measureClick.y, measureClick.pending = x, y, true
+	measureClick.Unlock()
+}
+
+// returns the click waiting to be measured, false if there is none
+func takeMeasureClick() (float64, float64, bool) {
+	measureClick.Lock()
+	defer measureClick.Unlock()
+	if !measureClick.pending {
+		return 0, 0, false
+	}
+	measureClick.pending = false
+	return measureClick.x, measureClick.y, true
+}
+
+// converts earth centered, earth fixed coordinates (m) to geodetic latitude, longitude (degrees) and
+// height above the WGS-84 ellipsoid (m)
+func ecefToGeodetic(p [3]float64) (float64, float64, float64) {
+	lon := math.Atan2(p[1], p[0])
+	r := math.Hypot(p[0], p[1])
+	lat := math.Atan2(p[2], r*(1-e2))
+	h := 0.0
+	for i := 0; i < 10; i++ {
+		sin, cos := math.Sincos(lat)
+		n := a / math.Sqrt(1-e2*sin*sin)
+		if math.Abs(cos) > 1e-9 {
+			h = r/cos - n
+		} else {
+			h = math.Abs(p[2]) - polarRadius
+		}
+		lat = math.Atan2(p[2], r*(1-e2*n/(n+h)))
+	}
+	return lat * (180 / math.Pi), lon * (180 / math.Pi), h
+}
+
+// returns the length (m) of the geodesic between two points on the WGS-84 ellipsoid (Vincenty's inverse formula).
+// for nearly antipodal points where it doesn't converge, the great circle distance on the mean sphere is returned
+func geodesicDistance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	f := 1 / rf
+	toRad := math.Pi / 180
+	l := (lon2 - lon1) * toRad
+	u1 := math.Atan((1 - f) * math.Tan(lat1*toRad))
+	u2 := math.Atan((1 - f) * math.Tan(lat2*toRad))
+	sinU1, cosU1 := math.Sincos(u1)
+	sinU2, cosU2 := math.Sincos(u2)
+
+	lambda := l
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma := math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma := sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma := math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha := 1 - sinAlpha*sinAlpha
+		cos2SigmaM := 0.0
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		c := f / 16 * cos2Alpha * (4 + f*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = l + (1-c)*f*sinAlpha*(sigma+c*sinSigma*(cos2SigmaM+c*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			uu := cos2Alpha * (a*a - polarRadius*polarRadius) / (polarRadius * polarRadius)
+			aa := 1 + uu/16384*(4096+uu*(-768+uu*(320-175*uu)))
+			bb := uu / 1024 * (256 + uu*(-128+uu*(74-47*uu)))
+			deltaSigma := bb * sinSigma * (cos2SigmaM + bb/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+				bb/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+			return polarRadius * aa * (sigma - deltaSigma)
+		}
+	}
+
+	sin1, cos1 := math.Sincos(lat1 * toRad)
+	sin2, cos2 := math.Sincos(lat2 * toRad)
+	angle := math.Acos(math.Max(-1, math.Min(1, sin1*sin2+cos1*cos2*math.Cos(l))))
+	return angle * (2*a + polarRadius) / 3
+}
+
+// returns the azimuth (degrees from north, 0-360) and elevation (degrees above the horizon) of q seen from p,
+// both earth fixed (m)
+func azimuthElevation(p [3]float64, q [3]float64) (float64, float64) {
+	lat, lon, _ := ecefToGeodetic(p)
+	sinLat, cosLat := math.Sincos(lat * (math.Pi / 180))
+	sinLon, cosLon := math.Sincos(lon * (math.Pi / 180))
+
+	d := [3]float64{q[0] - p[0], q[1] - p[1], q[2] - p[2]}
+	east := -sinLon*d[0] + cosLon*d[1]
+	north := -sinLat*cosLon*d[0] - sinLat*sinLon*d[1] + cosLat*d[2]
+	up := cosLat*cosLon*d[0] + cosLat*sinLon*d[1] + sinLat*d[2]
+
+	az := math.Atan2(east, north) * (180 / math.Pi)
+	if az < 0 {
+		az += 360
+	}
+	el := math.Atan2(up, math.Hypot(east, north)) * (180 / math.Pi)
+	return az, el
+}
+
+// measures the slant range, ground distance, azimuth and elevation from one point to another
+func newMeasurement(from *measurePoint, to *measurePoint) measurement {
+	d := [3]float64{to.pos[0] - from.pos[0], to.pos[1] - from.pos[1], to.pos[2] - from.pos[2]}
+	slant := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	lat1, lon1, h1 := ecefToGeodetic(from.pos)
+	lat2, lon2, h2 := ecefToGeodetic(to.pos)
+	ground := geodesicDistance(lat1, lon1, lat2, lon2)
+	az, el := azimuthElevation(from.pos, to.pos)
+
+	return measurement{
+		name: fmt.Sprintf("%.1f km, az %.1f, el %.1f", slant/1000, az, el),
+		report: []string{
+			fmt.Sprintf("From: %s (%.4f, %.4f, %.0f m)", from.name, lat1, lon1, h1),
+			fmt.Sprintf("To: %s (%.4f, %.4f, %.0f m)", to.name, lat2, lon2, h2),
+			fmt.Sprintf("Slant range: %.3f km", slant/1000),
+			fmt.Sprintf("Ground distance: %.3f km", ground/1000),
+			fmt.Sprintf("Azimuth: %.2f deg", az),
+			fmt.Sprintf("Elevation: %.2f deg", el),
+		},
+		from: from.pos,
+		to:   to.pos,
+	}
+}
+
+// returns the line vertices of a measurement
+func (m *measurement) vertices() []float32 {
+	c := measureColor
+	return []float32{
+		float32(m.from[0] / a), float32(m.from[1] / a), float32(m.from[2] / a), c[0], c[1], c[2],
+		float32(m.to[0] / a), float32(m.to[1] / a), float32(m.to[2] / a), c[0], c[1], c[2],
+	}
+}
+
+// returns the model space vertex of a drawn feature closest to the cursor on screen, false if none is on screen.
+// vertices are the kml vertices, icons the icon vertices
+func nearestFeatureVertex(vertices []float32, icons []float32, f feature, mvp mgl32.Mat4, cursor mgl32.Vec2) (mgl32.Vec3, bool) {
+	best, bestDist, found := mgl32.Vec3{}, float32(math.MaxFloat32), false
+	check := func(p mgl32.Vec3) {
+		if s, ok := projectToScreen(p, mvp); ok {
+			if d := s.Sub(cursor).Len(); d < bestDist {
+				best, bestDist, found = p, d, true
+			}
+		}
+	}
+	for _, r := range []struct {
+		show bool
+		vertexRange
+	}{
+		{state.showLines, f.lines},
+		{state.showPoints, f.points},
+		{state.showOrbits, f.orbits},
+		{state.showGroundTracks, f.groundTrack},
+	} {
+		for i := r.first; r.show && i < r.first+r.count; i++ {
+			check(vertexPos(vertices, i))
+		}
+	}
+	for i := f.icons.first; state.showPoints && i < f.icons.first+f.icons.count; i++ {
+		pos, _ := iconAt(icons, i)
+		check(pos)
+	}
+	return best, found
+}
+
+// returns the model space point where the ray through the cursor first meets the ellipsoid, false if it misses
+func globeAt(mvp mgl32.Mat4, cursor mgl32.Vec2) (mgl32.Vec3, bool) {
+	inv := mvp.Inv()
+	ndcX := 2*cursor[0]/float32(width) - 1
+	ndcY := 1 - 2*cursor[1]/float32(height)
+	near := inv.Mul4x1(mgl32.Vec4{ndcX, ndcY, -1, 1})
+	far := inv.Mul4x1(mgl32.Vec4{ndcX, ndcY, 1, 1})
+	if near[3] == 0 || far[3] == 0 {
+		return mgl32.Vec3{}, false
+	}
+	p := near.Vec3().Mul(1 / near[3])
+	q := far.Vec3().Mul(1 / far[3])
+
+	hit := rayEllipsoid(
+		[3]float64{float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a},
+		[3]float64{float64(q[0]-p[0]) * a, float64(q[1]-p[1]) * a, float64(q[2]-p[2]) * a})
+	if _, _, h := ecefToGeodetic(hit); math.Abs(h) > 1 {
+		return mgl32.Vec3{}, false
+	}
+	return mgl32.Vec3{float32(hit[0] / a), float32(hit[1] / a), float32(hit[2] / a)}, true
+}
+
+// measures at a click in the window: the first click marks the start, the second completes a measurement.
+// the clicked point is the picked feature's vertex closest to the cursor, else the globe under the cursor.
+// vertices are the kml vertices, icons the icon vertices
+func measureAt(x float64, y float64, vertices []float32, icons []float32, mvp mgl32.Mat4) {
+	cursor := mgl32.Vec2{float32(x), float32(y)}
+	var point *measurePoint
+	if picked >= 0 && picked < len(pickFeatures) {
+		if p, ok := nearestFeatureVertex(vertices, icons, pickFeatures[picked], mvp, cursor); ok {
+			point = &measurePoint{name: pickFeatures[picked].Name, pos: [3]float64{float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a}}
+		}
+	}
+	if point == nil {
+		p, ok := globeAt(mvp, cursor)
+		if !ok {
+			return
+		}
+		pos := [3]float64{float64(p[0]) * a, float64(p[1]) * a, float64(p[2]) * a}
+		lat, lon, _ := ecefToGeodetic(pos)
+		point = &measurePoint{name: fmt.Sprintf("%.4f, %.4f", lat, lon), pos: pos}
+	}
+
+	if measureFrom == nil {
+		measureFrom = point
+		showReport("Measurement", []string{"From: " + point.name, "Click a second feature or location."})
+		return
+	}
+	m := newMeasurement(measureFrom, point)
+	measureFrom = nil
+	showReport(m.name, m.report)
+
+	mutex.Lock()
+	measurements = append(measurements, m)
+	mutex.Unlock()
+	state.resetting = true
+}
+
+// shows a report in the details pane of the gui
+func showReport(name string, report []string) {
+	if app == nil {
+		return
+	}
+	text := detailsText(name, reportHTML(report))
+	app.QueueUpdateDraw(func() {
+		detailsView.SetText(text).ScrollToBeginning()
+	})
+}
+
+// shows a report in the details pane once the gui runs, and prints it to stdout before, since the
+// terminal belongs to the gui once it runs
+func report(title string, lines ...string) {
+	if app == nil {
+		fmt.Println(title)
+		for _, line := range lines {
+			fmt.Println("  " + line)
+		}
+		return
+	}
+	showReport(title, lines)
+}
+
+// removes all measurements
+func clearMeasurements() {
+	mutex.Lock()
+	measurements = nil
+	mutex.Unlock()
+	state.resetting = true
+}
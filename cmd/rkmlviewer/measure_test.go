@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestECEFToGeodetic(t *testing.T) {
+	tests := []struct {
+		name        string
+		lat, lon, h float64
+	}{
+		{"equator at the prime meridian", 0, 0, 0},
+		{"north pole", 90, 0, 100},
+		{"south pole", -90, 0, 0},
+		{"45 north 45 east at 1000 m", 45, 45, 1000},
+		{"below the ellipsoid", -33.5, 151, -50},
+		{"high orbit", 10, -120, 20000000},
+		{"antimeridian", 60, 180, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lon, h := ecefToGeodetic(geodeticToECEF(tt.lat, tt.lon, tt.h))
+			// longitude is undefined at the poles, and 180 and -180 are the same
+			dLon := math.Mod(lon-tt.lon+540, 360) - 180
+			if math.Abs(lat-tt.lat) > 1e-9 || math.Abs(tt.lat) != 90 && math.Abs(dLon) > 1e-9 || math.Abs(h-tt.h) > 1e-4 {
+				t.Errorf("ecefToGeodetic(geodeticToECEF(%v, %v, %v)) = %v, %v, %v", tt.lat, tt.lon, tt.h, lat, lon, h)
+			}
+		})
+	}
+
+	// reference values for WGS-84
+	lat, lon, h := ecefToGeodetic([3]float64{3194919.145, 3194919.145, 4488055.516})
+	if math.Abs(lat-45) > 1e-8 || math.Abs(lon-45) > 1e-8 || math.Abs(h-1000) > 1e-3 {
+		t.Errorf("ecefToGeodetic(3194919.145, 3194919.145, 4488055.516) = %v, %v, %v, want 45, 45, 1000", lat, lon, h)
+	}
+}
+
+func TestGeodesicDistance(t *testing.T) {
+	// degrees, minutes and seconds
+	dms := func(d float64, m float64, s float64) float64 {
+		return math.Copysign(math.Abs(d)+m/60+s/3600, d)
+	}
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want, tolerance        float64
+	}{
+		{"same point", 12, 34, 12, 34, 0, 0},
+		// Vincenty's worked example, from Flinders Peak to Buninyong
+		{"Flinders Peak to Buninyong", dms(-37, 57, 3.72030), dms(144, 25, 29.52440), dms(-37, 39, 10.15610), dms(143, 55, 35.38390), 54972.271, 1e-3},
+		{"quarter of the equator", 0, 0, 0, 90, a * math.Pi / 2, 1e-3},
+		{"equator to pole", 0, 0, 90, 0, 10001965.729, 1e-3},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, a * math.Pi / 180, 1e-3},
+		// not converging, the great circle distance on the mean sphere is close
+		{"antipodal", 0, 0, 0, 180, 20003931.458, 20000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := geodesicDistance(tt.lat1, tt.lon1, tt.lat2, tt.lon2)
+			if math.IsNaN(got) || math.Abs(got-tt.want) > tt.tolerance {
+				t.Errorf("geodesicDistance(%v, %v, %v, %v) = %.4f, want %.4f", tt.lat1, tt.lon1, tt.lat2, tt.lon2, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAzimuthElevation(t *testing.T) {
+	equator := [3]float64{a, 0, 0}
+	tests := []struct {
+		name   string
+		p, q   [3]float64
+		az, el float64
+	}{
+		{"north", equator, [3]float64{a, 0, 1000}, 0, 0},
+		{"north east", equator, [3]float64{a, 1000, 1000}, 45, 0},
+		{"east", equator, [3]float64{a, 1000, 0}, 90, 0},
+		{"south", equator, [3]float64{a, 0, -1000}, 180, 0},
+		{"west", equator, [3]float64{a, -1000, 0}, 270, 0},
+		{"rising to the east", equator, [3]float64{a + 1000, 1000, 0}, 90, 45},
+		{"down to the north", equator, [3]float64{a - 1000, 0, 1000}, 0, -45},
+		// up is along the ellipsoid normal, not away from the earth center
+		{"zenith at 45 north", geodeticToECEF(45, 45, 0), geodeticToECEF(45, 45, 1000), 0, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			az, el := azimuthElevation(tt.p, tt.q)
+			if math.Abs(el-tt.el) > 1e-6 || math.Abs(tt.el) != 90 && math.Abs(az-tt.az) > 1e-6 {
+				t.Errorf("azimuthElevation(%v, %v) = %v, %v, want %v, %v", tt.p, tt.q, az, el, tt.az, tt.el)
+			}
+		})
+	}
+}
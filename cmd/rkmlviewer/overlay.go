@@ -0,0 +1,210 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// ground overlays are drawn this far above the globe (earth radii) so they don't z-fight with it
+	groundOverlayLift = 0.001
+
+	// mesh resolution of a ground overlay spanning the whole globe, smaller overlays use fewer cells
+	groundOverlayStacks  = 64
+	groundOverlaySectors = 128
+)
+
+// groundOverlayMesh is a ground overlay ready to draw
+type groundOverlayMesh struct {
+	vertexArray uint32
+	count       int32
+	texture     uint32
+	color       mgl32.Vec4
+}
+
+// screenOverlayQuad is a screen overlay ready to draw
+type screenOverlayQuad struct {
+	vertexArray uint32
+	texture     uint32
+}
+
+// returns every ground and screen overlay in f and its subfolders
+func collectOverlays(f Folder) ([]GroundOverlay, []ScreenOverlay) {
+	grounds := append([]GroundOverlay{}, f.Grounds...)
+	screens := append([]ScreenOverlay{}, f.Screens...)
+	for i := range f.Folders {
+		g, s := collectOverlays(f.Folders[i])
+		grounds = append(grounds, g...)
+		screens = append(screens, s...)
+	}
+	return grounds, screens
+}
+
+// loads the images of the visible overlays and builds their meshes. overlays whose image can't be loaded are skipped
+func loadOverlays(f Folder) ([]groundOverlayMesh, []screenOverlayQuad) {
+	groundOverlays, screenOverlays := collectOverlays(f)
+
+	grounds := []groundOverlayMesh{}
+	for _, o := range groundOverlays {
+		if o.Visibility != nil && !*o.Visibility {
+			continue
+		}
+		img, ok := loadLinkedImage(o.Icon.Href)
+		if !ok {
+			addWarning("Ground overlay image could not be loaded: " + o.Icon.Href)
+			continue
+		}
+
+		box := o.LatLonBox
+		span := box.East - box.West
+		if span < 0 {
+			span += 360
+		}
+		stacks := int(math.Max(2, math.Ceil(groundOverlayStacks*math.Abs(box.North-box.South)/180)))
+		sectors := int(math.Max(2, math.Ceil(groundOverlaySectors*span/360)))
+
+		r := radius + groundOverlayLift
+		if o.AltitudeMode == "absolute" {
+			r = radius + math.Max(o.Altitude/a, groundOverlayLift)
+		}
+
+		// the box is shifted by the frame offset like every other kml coordinate
+		vertices, indices := generateSpherePatch(sectors, stacks, r, box.North, box.South,
+			box.East+frameLonOffset, box.West+frameLonOffset, box.Rotation, o.AltitudeMode != "absolute")
+		rect := img.Bounds()
+		grounds = append(grounds, groundOverlayMesh{
+			vertexArray: makeVaoEarth(vertices, indices, 8*4),
+			count:       int32(len(indices)),
+			texture:     textureFromPixels(img.Pix, int32(rect.Dx()), int32(rect.Dy()), colorRGBA),
+			color:       parseKMLColor(o.Color),
+		})
+	}
+
+	screens := []screenOverlayQuad{}
+	for _, o := range screenOverlays {
+		if o.Visibility != nil && !*o.Visibility {
+			continue
+		}
+		img, ok := loadLinkedImage(o.Icon.Href)
+		if !ok {
+			addWarning("Screen overlay image could not be loaded: " + o.Icon.Href)
+			continue
+		}
+
+		rect := img.Bounds()
+		vertexArray, vertexBuffer := makeVaoLabels(labelStride * 4)
+		vertices := screenOverlayVertices(o, float32(rect.Dx()), float32(rect.Dy()))
+		gl.BindBuffer(gl.ARRAY_BUFFER, vertexBuffer)
+		gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.STATIC_DRAW)
+		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+		screens = append(screens, screenOverlayQuad{
+			vertexArray: vertexArray,
+			texture:     textureFromPixels(img.Pix, int32(rect.Dx()), int32(rect.Dy()), colorRGBA),
+		})
+	}
+
+	return grounds, screens
+}
+
+// converts an overlay x or y value to pixels along a length (image or window size)
+func overlayUnits(v float64, units string, length float32) float32 {
+	switch units {
+	case "pixels":
+		return float32(v)
+	case "insetPixels":
+		return length - float32(v)
+	}
+	return float32(v) * length
+}
+
+// returns the two triangles of a screen overlay in window pixels (origin top left), in the label vertex layout.
+// imageWidth and imageHeight are the native size of the image
+func screenOverlayVertices(o ScreenOverlay, imageWidth float32, imageHeight float32) []float32 {
+	// size: -1 keeps the native size, 0 keeps the aspect ratio of the other dimension
+	w, h := imageWidth, imageHeight
+	if o.Size.X > 0 {
+		w = overlayUnits(o.Size.X, o.Size.XUnits, float32(width))
+	}
+	if o.Size.Y > 0 {
+		h = overlayUnits(o.Size.Y, o.Size.YUnits, float32(height))
+	}
+	if o.Size.X == 0 && o.Size.Y > 0 {
+		w = h * imageWidth / imageHeight
+	}
+	if o.Size.Y == 0 && o.Size.X > 0 {
+		h = w * imageHeight / imageWidth
+	}
+
+	// KML measures from the bottom left, so overlayXY on the image is placed on screenXY with y up
+	sx := overlayUnits(o.ScreenXY.X, o.ScreenXY.XUnits, float32(width))
+	sy := overlayUnits(o.ScreenXY.Y, o.ScreenXY.YUnits, float32(height))
+	left := sx - overlayUnits(o.OverlayXY.X, o.OverlayXY.XUnits, w)
+	bottom := sy - overlayUnits(o.OverlayXY.Y, o.OverlayXY.YUnits, h)
+
+	// corners counterclockwise about the screen point, then flipped to y down
+	rot := float64(mgl32.DegToRad(float32(o.Rotation)))
+	corner := func(x float32, y float32) (float32, float32) {
+		dx, dy := float64(x-sx), float64(y-sy)
+		rx := float32(dx*math.Cos(rot)-dy*math.Sin(rot)) + sx
+		ry := float32(dx*math.Sin(rot)+dy*math.Cos(rot)) + sy
+		return rx, float32(height) - ry
+	}
+	x0, y0 := corner(left, bottom+h) // top left
+	x1, y1 := corner(left+w, bottom+h)
+	x2, y2 := corner(left+w, bottom)
+	x3, y3 := corner(left, bottom)
+
+	c := parseKMLColor(o.Color)
+	return []float32{
+		x0, y0, 0, 0, c[0], c[1], c[2], c[3],
+		x1, y1, 1, 0, c[0], c[1], c[2], c[3],
+		x2, y2, 1, 1, c[0], c[1], c[2], c[3],
+		x0, y0, 0, 0, c[0], c[1], c[2], c[3],
+		x2, y2, 1, 1, c[0], c[1], c[2], c[3],
+		x3, y3, 0, 1, c[0], c[1], c[2], c[3],
+	}
+}
+
+// draws the ground overlays over the globe
+func drawGroundOverlays(program *shaderProgram, grounds []groundOverlayMesh) {
+	if len(grounds) == 0 {
+		return
+	}
+
+	program.use()
+	gl.Enable(gl.BLEND)
+	gl.ActiveTexture(gl.TEXTURE6)
+	for _, o := range grounds {
+		program.set("overlayColor", o.color)
+		gl.BindTexture(gl.TEXTURE_2D, o.texture)
+		gl.BindVertexArray(o.vertexArray)
+		gl.DrawElements(gl.TRIANGLES, o.count, gl.UNSIGNED_INT, gl.PtrOffset(0))
+	}
+	if !state.enableBlending {
+		gl.Disable(gl.BLEND)
+	}
+}
+
+// draws the screen overlays on top of everything else
+func drawScreenOverlays(program *shaderProgram, screens []screenOverlayQuad) {
+	if len(screens) == 0 {
+		return
+	}
+
+	program.use()
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+	gl.ActiveTexture(gl.TEXTURE6)
+	for _, o := range screens {
+		gl.BindTexture(gl.TEXTURE_2D, o.texture)
+		gl.BindVertexArray(o.vertexArray)
+		gl.DrawArrays(gl.TRIANGLES, 0, 6)
+	}
+	gl.Enable(gl.DEPTH_TEST)
+	if !state.enableBlending {
+		gl.Disable(gl.BLEND)
+	}
+}
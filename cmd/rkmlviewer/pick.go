@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// distance in pixels from the cursor within which a feature is picked
+const pickRadius = 6.0
+
+// vertexRange is a run of vertices in the object vertex array (6 floats each) or icon vertex array (iconStride floats each)
+type vertexRange struct {
+	first int32
+	count int32
+}
+
+// feature is a rendered kml feature and the location of its vertices, relative to the start of the kml vertices
+type feature struct {
+	Name        string
+	Description string
+	lines       vertexRange
+	points      vertexRange
+	orbits      vertexRange
+	groundTrack vertexRange
+	icons       vertexRange
+	inertial    bool // coordinates are in the ECI frame
+	groundFixed bool // the ground track is in the earth fixed frame even if the coordinates are not
+}
+
+var (
+	// features currently drawn, rebuilt by interpretSelected
+	pickFeatures []feature
+
+	// index into pickFeatures of the feature under the cursor, -1 if none
+	picked = -1
+)
+
+// returns the index of the feature under the cursor at (x, y), or -1.
+// vertices are the kml vertices, icons the icon vertices, mvp the full projection*camera*model transform
+func pickFeature(vertices []float32, icons []float32, mvp mgl32.Mat4, model mgl32.Mat4, eye mgl32.Vec3, x float64, y float64) int {
+	cursor := mgl32.Vec2{float32(x), float32(y)}
+	best := -1
+	bestDist := float32(pickRadius)
+
+	// checks the vertex (or segment to the next vertex) at index i
+	check := func(index int, i int32, segment bool) {
+		p1, ok1 := toScreen(vertices, i, mvp)
+		if !ok1 {
+			return
+		}
+		d, t := cursor.Sub(p1).Len(), float32(0)
+		if segment {
+			p2, ok2 := toScreen(vertices, i+1, mvp)
+			if !ok2 {
+				return
+			}
+			d, t = distanceToSegment(cursor, p1, p2)
+		}
+		if d >= bestDist {
+			return
+		}
+
+		// skip anything hidden behind the earth
+		world := vertexPos(vertices, i)
+		if segment {
+			world = world.Add(vertexPos(vertices, i+1).Sub(world).Mul(t))
+		}
+		if state.showEarth && occluded(eye, model.Mul4x1(world.Vec4(1)).Vec3()) {
+			return
+		}
+
+		best, bestDist = index, d
+	}
+
+	for index, f := range pickFeatures {
+		if state.showLines {
+			for i := f.lines.first; i+1 < f.lines.first+f.lines.count; i += 2 {
+				check(index, i, true)
+			}
+		}
+		if state.showPoints {
+			for i := f.points.first; i < f.points.first+f.points.count; i++ {
+				check(index, i, false)
+			}
+		}
+		if state.showOrbits {
+			for i := f.orbits.first; i+1 < f.orbits.first+f.orbits.count; i += 2 {
+				check(index, i, true)
+			}
+		}
+		if state.showGroundTracks {
+			for i := f.groundTrack.first; i+1 < f.groundTrack.first+f.groundTrack.count; i += 2 {
+				check(index, i, true)
+			}
+		}
+		if state.showPoints {
+			// icons are picked anywhere inside the drawn square
+			for i := f.icons.first; i < f.icons.first+f.icons.count; i++ {
+				pos, size := iconAt(icons, i)
+				p, ok := projectToScreen(pos, mvp)
+				if !ok {
+					continue
+				}
+				d := cursor.Sub(p).Len() - size/2
+				if d < 0 {
+					d = 0
+				}
+				if d < bestDist && !(state.showEarth && occluded(eye, model.Mul4x1(pos.Vec4(1)).Vec3())) {
+					best, bestDist = index, d
+				}
+			}
+		}
+	}
+
+	return best
+}
+
+// returns the model space position of vertex i
+func vertexPos(vertices []float32, i int32) mgl32.Vec3 {
+	return mgl32.Vec3{vertices[i*6], vertices[i*6+1], vertices[i*6+2]}
+}
+
+// projects vertex i into window coordinates, returns false if it is behind the camera
+func toScreen(vertices []float32, i int32, mvp mgl32.Mat4) (mgl32.Vec2, bool) {
+	return projectToScreen(vertexPos(vertices, i), mvp)
+}
+
+// projects a model space position into window coordinates, returns false if it is behind the camera
+func projectToScreen(p mgl32.Vec3, mvp mgl32.Mat4) (mgl32.Vec2, bool) {
+	clip := mvp.Mul4x1(p.Vec4(1))
+	if clip[3] <= 0 {
+		return mgl32.Vec2{}, false
+	}
+	return mgl32.Vec2{
+		(clip[0]/clip[3] + 1) / 2 * float32(width),
+		(1 - clip[1]/clip[3]) / 2 * float32(height),
+	}, true
+}
+
+// returns the distance from p to the segment a-b and how far along the segment the closest point is (0-1)
+func distanceToSegment(p mgl32.Vec2, a mgl32.Vec2, b mgl32.Vec2) (float32, float32) {
+	ab := b.Sub(a)
+	l := ab.Dot(ab)
+	if l == 0 {
+		return p.Sub(a).Len(), 0
+	}
+	t := mgl32.Clamp(p.Sub(a).Dot(ab)/l, 0, 1)
+	return p.Sub(a.Add(ab.Mul(t))).Len(), t
+}
+
+// returns true if the line of sight from eye to p passes through the earth
+func occluded(eye mgl32.Vec3, p mgl32.Vec3) bool {
+	// stretch the ellipsoid into a sphere, then solve |eye + t*d| = radius for t in (0, 1),
+	// slightly shrunk so surface points stay visible
+	pole := earthModel().Mul4x1(mgl32.Vec4{0, 0, 1, 0}).Vec3()
+	eye = ellipsoidToSphere(eye, pole)
+	p = ellipsoidToSphere(p, pole)
+	r := float32(radius) * 0.999
+	d := p.Sub(eye)
+	qa := d.Dot(d)
+	qb := 2 * eye.Dot(d)
+	qc := eye.Dot(eye) - r*r
+	disc := qb*qb - 4*qa*qc
+	if qa == 0 || disc < 0 {
+		return false
+	}
+	sq := float32(math.Sqrt(float64(disc)))
+	t1 := (-qb - sq) / (2 * qa)
+	t2 := (-qb + sq) / (2 * qa)
+	return (t1 > 0 && t1 < 1) || (t2 > 0 && t2 < 1)
+}
+
+// draws the picked feature again on top of itself in the highlight color.
+// offset is the index of the first kml vertex in the bound vertex array
+func drawHighlight(program *shaderProgram, offset int32) {
+	if picked < 0 || picked >= len(pickFeatures) {
+		return
+	}
+	f := pickFeatures[picked]
+
+	program.set("highlight", true)
+	gl.DepthFunc(gl.LEQUAL)
+	gl.PointSize(float32(pointSize) * 2)
+
+	if state.showLines && f.lines.count > 0 {
+		gl.DrawArrays(gl.LINES, offset+f.lines.first, f.lines.count)
+	}
+	if state.showPoints && f.points.count > 0 {
+		gl.DrawArrays(gl.POINTS, offset+f.points.first, f.points.count)
+	}
+	if state.showOrbits && f.orbits.count > 0 {
+		gl.DrawArrays(gl.LINES, offset+f.orbits.first, f.orbits.count)
+	}
+	if state.showGroundTracks && f.groundTrack.count > 0 {
+		gl.DrawArrays(gl.LINES, offset+f.groundTrack.first, f.groundTrack.count)
+	}
+
+	gl.PointSize(float32(pointSize))
+	gl.DepthFunc(gl.LESS)
+	program.set("highlight", false)
+}
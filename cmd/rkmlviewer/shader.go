@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// seconds between checks of the shader files for changes
+const shaderCheckInterval = 0.5
+
+// matches a uniform declaration in GLSL source, e.g. "uniform mat4 camera;"
+var uniformDeclaration = regexp.MustCompile(`\buniform\s+\w+\s+(\w+)`)
+
+// shaderProgram is a linked vertex and fragment shader pair that is rebuilt when its files change.
+// ID stays the same across rebuilds, so it can be kept by the render loop
+type shaderProgram struct {
+	ID           uint32
+	vertexPath   string
+	fragmentPath string
+
+	// modification times of the shader files on disk when last built, zero for built in shaders
+	modified [2]time.Time
+
+	// uniform locations looked up so far (-1 if the program has none), cleared when the program is rebuilt
+	uniforms map[string]int32
+
+	// names of the uniforms declared in the shader sources, active or not
+	declared map[string]bool
+}
+
+// uniformValue is the value of one active uniform, kept across a relink
+type uniformValue struct {
+	name   string
+	xtype  uint32
+	floats [16]float32
+	ints   [4]int32
+}
+
+var (
+	// every program made by newProgram, checked by reloadShaders
+	shaderPrograms []*shaderProgram
+
+	// glfw time of the last check for changed shader files
+	lastShaderCheck float64
+)
+
+// reads the shader files, compiles and links them and returns the program. exits if they don't build
+func newProgram(vertexShaderS string, fragmentShaderS string) *shaderProgram {
+	p := &shaderProgram{ID: gl.CreateProgram(), vertexPath: vertexShaderS, fragmentPath: fragmentShaderS}
+	if err := p.build(); err != nil {
+		log.Fatal(err)
+	}
+	shaderPrograms = append(shaderPrograms, p)
+	return p
+}
+
+// compiles both shaders and links them into a test program first, so a broken shader leaves the
+// program unchanged. on success the program is relinked in place and its uniform values restored
+func (p *shaderProgram) build() error {
+	p.modified = [2]time.Time{shaderModTime(p.vertexPath), shaderModTime(p.fragmentPath)}
+
+	vertexShader, vertexSource, err := loadShader(p.vertexPath, gl.VERTEX_SHADER)
+	if err != nil {
+		return err
+	}
+	fragmentShader, fragmentSource, err := loadShader(p.fragmentPath, gl.FRAGMENT_SHADER)
+	if err != nil {
+		gl.DeleteShader(vertexShader)
+		return err
+	}
+	// flag the shaders for deletion, they are freed when no program has them attached
+	defer gl.DeleteShader(vertexShader)
+	defer gl.DeleteShader(fragmentShader)
+
+	test := gl.CreateProgram()
+	gl.AttachShader(test, vertexShader)
+	gl.AttachShader(test, fragmentShader)
+	gl.LinkProgram(test)
+	err = linkStatus(test)
+	gl.DeleteProgram(test)
+	if err != nil {
+		return fmt.Errorf("failed to link %s and %s: %v", p.vertexPath, p.fragmentPath, err)
+	}
+
+	values := saveUniforms(p.ID)
+
+	var count int32
+	attached := make([]uint32, 8)
+	gl.GetAttachedShaders(p.ID, int32(len(attached)), &count, &attached[0])
+	for _, s := range attached[:count] {
+		gl.DetachShader(p.ID, s)
+	}
+	gl.AttachShader(p.ID, vertexShader)
+	gl.AttachShader(p.ID, fragmentShader)
+	gl.LinkProgram(p.ID)
+	if err := linkStatus(p.ID); err != nil {
+		return fmt.Errorf("failed to link %s and %s: %v", p.vertexPath, p.fragmentPath, err)
+	}
+
+	restoreUniforms(p.ID, values)
+
+	p.uniforms = make(map[string]int32)
+	p.declared = make(map[string]bool)
+	for _, source := range []string{vertexSource, fragmentSource} {
+		for _, match := range uniformDeclaration.FindAllStringSubmatch(source, -1) {
+			p.declared[match[1]] = true
+		}
+	}
+	return nil
+}
+
+// makes the program the current one, uniforms are set on the current program
+func (p *shaderProgram) use() {
+	gl.UseProgram(p.ID)
+}
+
+// returns the location of a uniform, -1 if the program has no such active uniform. the first lookup of a
+// uniform that isn't declared in either shader adds a warning, declared uniforms the compiler removed
+// because they are unused are ignored silently
+func (p *shaderProgram) location(name string) int32 {
+	if location, ok := p.uniforms[name]; ok {
+		return location
+	}
+	location := gl.GetUniformLocation(p.ID, gl.Str(name+"\x00"))
+	if location < 0 && !p.declared[name] {
+		addWarning(fmt.Sprintf("Shader program %s and %s has no uniform %s", p.vertexPath, p.fragmentPath, name))
+	}
+	p.uniforms[name] = location
+	return location
+}
+
+// sets a uniform of the program, which must be in use. supports mat4, mat3, vec4, vec3, vec2, float32,
+// float64, int, int32 and bool (as int) values; other types are a programming error and panic
+func (p *shaderProgram) set(name string, value interface{}) {
+	location := p.location(name)
+	if location < 0 {
+		return
+	}
+	switch v := value.(type) {
+	case mgl32.Mat4:
+		gl.UniformMatrix4fv(location, 1, false, &v[0])
+	case mgl32.Mat3:
+		gl.UniformMatrix3fv(location, 1, false, &v[0])
+	case mgl32.Vec4:
+		gl.Uniform4fv(location, 1, &v[0])
+	case mgl32.Vec3:
+		gl.Uniform3fv(location, 1, &v[0])
+	case mgl32.Vec2:
+		gl.Uniform2fv(location, 1, &v[0])
+	case float32:
+		gl.Uniform1f(location, v)
+	case float64:
+		gl.Uniform1f(location, float32(v))
+	case int:
+		gl.Uniform1i(location, int32(v))
+	case int32:
+		gl.Uniform1i(location, v)
+	case bool:
+		if v {
+			gl.Uniform1i(location, 1)
+		} else {
+			gl.Uniform1i(location, 0)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported type %T for uniform %s", value, name))
+	}
+}
+
+// returns true if either shader file changed on disk since the program was built
+func (p *shaderProgram) changed() bool {
+	return shaderModTime(p.vertexPath) != p.modified[0] || shaderModTime(p.fragmentPath) != p.modified[1]
+}
+
+// returns the modification time of a shader file found on disk, zero if it is built in
+func shaderModTime(name string) time.Time {
+	path, ok := findAsset(name)
+	if !ok {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reads and compiles one shader file, returns the shader and its source
+func loadShader(name string, shaderType uint32) (uint32, string, error) {
+	file, err := openAsset(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	source, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	shader, err := compileShader(string(source)+"\x00", shaderType)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", name, err)
+	}
+	return shader, string(source), nil
+}
+
+// compiles a shader
+func compileShader(source string, shaderType uint32) (uint32, error) {
+	shader := gl.CreateShader(shaderType)
+
+	csources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, csources, nil)
+	free()
+	gl.CompileShader(shader)
+
+	var status int32
+	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
+	if status == gl.FALSE {
+		var logLength int32
+		gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+
+		log := strings.Repeat("\x00", int(logLength+1))
+		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
+		gl.DeleteShader(shader)
+
+		return 0, fmt.Errorf("failed to compile: %v", strings.TrimRight(log, "\x00\n"))
+	}
+
+	return shader, nil
+}
+
+// returns the link log of a program as an error if linking failed
+func linkStatus(program uint32) error {
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.TRUE {
+		return nil
+	}
+
+	var logLength int32
+	gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+
+	log := strings.Repeat("\x00", int(logLength+1))
+	gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
+
+	return fmt.Errorf("%v", strings.TrimRight(log, "\x00\n"))
+}
+
+// returns the values of the active uniforms of a linked program
+func saveUniforms(program uint32) []uniformValue {
+	var count int32
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORMS, &count)
+
+	values := []uniformValue{}
+	name := make([]uint8, 256)
+	for i := uint32(0); i < uint32(count); i++ {
+		var length, size int32
+		var xtype uint32
+		gl.GetActiveUniform(program, i, int32(len(name)), &length, &size, &xtype, &name[0])
+
+		v := uniformValue{name: string(name[:length]), xtype: xtype}
+		location := gl.GetUniformLocation(program, gl.Str(v.name+"\x00"))
+		switch xtype {
+		case gl.FLOAT, gl.FLOAT_VEC2, gl.FLOAT_VEC3, gl.FLOAT_VEC4, gl.FLOAT_MAT3, gl.FLOAT_MAT4:
+			gl.GetUniformfv(program, location, &v.floats[0])
+		case gl.INT, gl.BOOL, gl.SAMPLER_2D:
+			gl.GetUniformiv(program, location, &v.ints[0])
+		default:
+			continue
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// sets saved uniform values on a relinked program, skipping uniforms it no longer has
+func restoreUniforms(program uint32, values []uniformValue) {
+	gl.UseProgram(program)
+	for _, v := range values {
+		location := gl.GetUniformLocation(program, gl.Str(v.name+"\x00"))
+		if location < 0 {
+			continue
+		}
+		switch v.xtype {
+		case gl.FLOAT:
+			gl.Uniform1fv(location, 1, &v.floats[0])
+		case gl.FLOAT_VEC2:
+			gl.Uniform2fv(location, 1, &v.floats[0])
+		case gl.FLOAT_VEC3:
+			gl.Uniform3fv(location, 1, &v.floats[0])
+		case gl.FLOAT_VEC4:
+			gl.Uniform4fv(location, 1, &v.floats[0])
+		case gl.FLOAT_MAT3:
+			gl.UniformMatrix3fv(location, 1, false, &v.floats[0])
+		case gl.FLOAT_MAT4:
+			gl.UniformMatrix4fv(location, 1, false, &v.floats[0])
+		case gl.INT, gl.BOOL, gl.SAMPLER_2D:
+			gl.Uniform1iv(location, 1, &v.ints[0])
+		}
+	}
+}
+
+// rebuilds the programs whose shader files changed, at most every shaderCheckInterval seconds.
+// reloads are shown in the details pane of the gui, failures in its warnings and keep the previous program
+func reloadShaders(now float64) {
+	if now-lastShaderCheck < shaderCheckInterval {
+		return
+	}
+	lastShaderCheck = now
+
+	for _, p := range shaderPrograms {
+		if !p.changed() {
+			continue
+		}
+		if err := p.build(); err != nil {
+			addWarning(fmt.Sprint("Shader reload failed, keeping the previous program: ", err))
+			continue
+		}
+		report("Shader reload", fmt.Sprintf("Reloaded shaders %s and %s", p.vertexPath, p.fragmentPath))
+	}
+}
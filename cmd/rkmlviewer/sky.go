@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	// apparent diameter of the sun in degrees, and radius of the moon in earth radii
+	sunDiameter = 0.533
+	moonRadius  = 1737.4e3 / a
+
+	// smallest size in pixels the sun and moon are drawn at, so they can be found at a wide field of view
+	skyDiscMinSize = 8.0
+)
+
+// star is a catalogue star, dir its unit direction in the ECI frame
+type star struct {
+	dir mgl32.Vec3
+	mag float32
+}
+
+// reads the bright star catalogue: name, right ascension (hours), declination (degrees) and visual magnitude
+// per line, after a header line. lines starting with # are comments
+func loadStars(path string) ([]star, error) {
+	file, err := openAsset(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	stars := []star{}
+	for i, rec := range records {
+		if i == 0 || len(rec) < 4 {
+			continue
+		}
+		ra, err1 := strconv.ParseFloat(rec[1], 64)
+		dec, err2 := strconv.ParseFloat(rec[2], 64)
+		mag, err3 := strconv.ParseFloat(rec[3], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		raRad := ra * 15 * (math.Pi / 180)
+		decRad := dec * (math.Pi / 180)
+		stars = append(stars, star{
+			dir: mgl32.Vec3{
+				float32(math.Cos(decRad) * math.Cos(raRad)),
+				float32(math.Cos(decRad) * math.Sin(raRad)),
+				float32(math.Sin(decRad)),
+			},
+			mag: float32(mag),
+		})
+	}
+	return stars, nil
+}
+
+// returns the position of the moon in the ECI frame in earth radii for a UTC time,
+// from the low precision lunar ephemeris of the Astronomical Almanac (about 0.3 degrees)
+func moonPositionECI(t time.Time) mgl32.Vec3 {
+	jd := float64(t.UnixNano())/(86400*1e9) + 2440587.5
+	c := (jd - 2451545.0) / 36525 // julian centuries since J2000
+
+	sin := func(deg float64) float64 { return math.Sin(deg * (math.Pi / 180)) }
+	cos := func(deg float64) float64 { return math.Cos(deg * (math.Pi / 180)) }
+
+	// ecliptic longitude, latitude and horizontal parallax in degrees
+	lambda := 218.32 + 481267.881*c +
+		6.29*sin(135.0+477198.87*c) - 1.27*sin(259.3-413335.36*c) +
+		0.66*sin(235.7+890534.22*c) + 0.21*sin(269.9+954397.74*c) -
+		0.19*sin(357.5+35999.05*c) - 0.11*sin(186.5+966404.03*c)
+	beta := 5.13*sin(93.3+483202.02*c) + 0.28*sin(228.2+960400.89*c) -
+		0.28*sin(318.3+6003.15*c) - 0.17*sin(217.6-407332.21*c)
+	parallax := 0.9508 + 0.0518*cos(135.0+477198.87*c) + 0.0095*cos(259.3-413335.36*c) +
+		0.0078*cos(235.7+890534.22*c) + 0.0028*cos(269.9+954397.74*c)
+
+	r := 1 / sin(parallax)
+	l := cos(beta) * cos(lambda)
+	m := 0.9175*cos(beta)*sin(lambda) - 0.3978*sin(beta)
+	n := 0.3978*cos(beta)*sin(lambda) + 0.9175*sin(beta)
+
+	return mgl32.Vec3{float32(r * l), float32(r * m), float32(r * n)}
+}
+
+// returns the pixel size of a disc with an apparent diameter in degrees
+func discSize(diameter float64) float32 {
+	size := float32(diameter / float64(camera.fov) * float64(height))
+	if size < skyDiscMinSize {
+		return skyDiscMinSize
+	}
+	return size
+}
+
+// returns the sky vertices (in the icon vertex layout, positions being world directions) for the stars,
+// sun and moon. model is the earth model matrix, the ECI directions of all of them are turned into the
+// earth fixed frame at the simulation time, so the stars keep their place relative to the sun
+func buildSky(stars []star, model mgl32.Mat4) []float32 {
+	vertices := []float32{}
+	appendVertex := func(dir mgl32.Vec3, color mgl32.Vec4, size float32) {
+		vertices = append(vertices, dir[0], dir[1], dir[2], color[0], color[1], color[2], color[3], 0, 0, size)
+	}
+
+	for _, s := range stars {
+		// brighter (lower magnitude) stars are bigger and more opaque
+		size := mgl32.Clamp(2+(2.5-s.mag)*0.8, 1.5, 5)
+		brightness := mgl32.Clamp(1.2-s.mag*0.25, 0.35, 1)
+		appendVertex(model.Mul4x1(eciToECEF(s.dir, simTime).Vec4(0)).Vec3(), mgl32.Vec4{1, 1, 1, brightness}, size)
+	}
+
+	appendVertex(sunPosition(model).Normalize(), mgl32.Vec4{1, 0.95, 0.8, 1}, discSize(sunDiameter))
+
+	// the moon is close enough for parallax, so its direction is taken from the camera
+	moon := model.Mul4x1(eciToECEF(moonPositionECI(simTime), simTime).Vec4(1)).Vec3().Sub(camera.Pos)
+	moonDiameter := 2 * math.Asin(moonRadius/float64(moon.Len())) * (180 / math.Pi)
+	appendVertex(moon.Normalize(), mgl32.Vec4{0.85, 0.85, 0.8, 1}, discSize(moonDiameter))
+
+	return vertices
+}
+
+// draws the sky vertices built by buildSky behind everything else
+func drawSky(program *shaderProgram, vertexArray uint32, vertexBuffer uint32, vertices []float32) {
+	if len(vertices) == 0 {
+		return
+	}
+
+	program.use()
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Enable(gl.BLEND)
+	gl.Enable(gl.PROGRAM_POINT_SIZE)
+
+	gl.BindVertexArray(vertexArray)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vertexBuffer)
+	gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.DYNAMIC_DRAW)
+	gl.DrawArrays(gl.POINTS, 0, int32(len(vertices)/iconStride))
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+
+	gl.Disable(gl.PROGRAM_POINT_SIZE)
+	gl.Enable(gl.DEPTH_TEST)
+	if !state.enableBlending {
+		gl.Disable(gl.BLEND)
+	}
+}
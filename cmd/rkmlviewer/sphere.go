@@ -2,75 +2,87 @@ package main
 
 import (
 	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
 )
 
 func generateSphere(sectorCount int, stackCount int, radius float64) ([]float32, []uint32) {
-	var PI float32
-	PI = 3.1415926
-	var vertices []float32
-	//fmt.Println(sectorCount, stackCount, radius)
+	// geodetic latitude from 90 to -90, longitude from -180 to 180 like the equirectangular textures
+	point := func(i int, j int) (float64, float64) {
+		return 90 - 180*float64(i)/float64(stackCount), 360*float64(j)/float64(sectorCount) - 180
+	}
+	return generateGrid(sectorCount, stackCount, radius, point, false, true)
+}
+
+// generates a patch of a sphere covering a lat/lon box (degrees), with the same vertex layout as generateSphere.
+// texture coordinates run from the north west (0, 0) to the south east (1, 1) corner of the box, which is
+// turned counterclockwise by rotation degrees about its center. if ground is set the patch follows the terrain
+func generateSpherePatch(sectorCount int, stackCount int, radius float64, north float64, south float64, east float64, west float64, rotation float64, ground bool) ([]float32, []uint32) {
+	if east < west {
+		// box crosses the antimeridian
+		east += 360
+	}
+	centerLat := (north + south) / 2
+	centerLon := (east + west) / 2
+	sin, cos := math.Sincos(rotation * (math.Pi / 180))
 
-	var x, y, z, xy float32
-	var nx, ny, nz float32
-	var lengthInv = float32(1.0 / radius)
-	var s, t float32
+	point := func(i int, j int) (float64, float64) {
+		lat := north - (north-south)*float64(i)/float64(stackCount)
+		lon := west + (east-west)*float64(j)/float64(sectorCount)
 
-	var sectorStep = 2 * PI / float32(sectorCount)
-	var stackStep = PI / float32(stackCount)
-	var sectorAngle, stackAngle float32
+		// rotate about the center of the box
+		dLon, dLat := lon-centerLon, lat-centerLat
+		return centerLat + dLon*sin + dLat*cos, centerLon + dLon*cos - dLat*sin
+	}
+	return generateGrid(sectorCount, stackCount, radius, point, ground, false)
+}
 
-	for i := 0; i <= stackCount; i++ {
-		stackAngle = PI/2 - float32(i)*stackStep             // starting from pi/2 to -pi/2
-		xy = float32(radius * math.Cos(float64(stackAngle))) // r * cos(u)
-		z = float32(radius * math.Sin(float64(stackAngle)))  // r * sin(u)
+// generates a grid of (sectorCount+1) x (stackCount+1) vertices on the ellipsoid scaled by radius and the
+// triangles between them. point returns the latitude and longitude (degrees) of the vertex in row i (from the top)
+// and column j. each vertex has its position, ellipsoid normal and texture coordinates (j/sectorCount, i/stackCount).
+// ground raises the vertices to the terrain, poles leaves out the degenerate triangles of a grid whose first
+// and last rows are the poles
+func generateGrid(sectorCount int, stackCount int, radius float64, point func(i int, j int) (float64, float64), ground bool, poles bool) ([]float32, []uint32) {
+	var vertices []float32
 
-		// add (sectorCount+1) vertices per stack
-		// the first and last vertices have same position and normal, but different tex coords
+	// add (sectorCount+1) vertices per stack
+	// the first and last vertices of a full circle have same position and normal, but different tex coords
+	for i := 0; i <= stackCount; i++ {
 		for j := 0; j <= sectorCount; j++ {
-			sectorAngle = float32(j) * sectorStep // starting from 0 to 2pi
-
-			// vertex position (x, y, z)
-			x = xy * float32(math.Cos(float64(sectorAngle))) // r * cos(u) * cos(v)
-			y = xy * float32(math.Sin(float64(sectorAngle))) // r * cos(u) * sin(v)
-			vertices = append(vertices, x)
-			vertices = append(vertices, y)
-			vertices = append(vertices, z)
-
-			// normalized vertex normal (nx, ny, nz)
-			nx = x * lengthInv
-			ny = y * lengthInv
-			nz = z * lengthInv
-			vertices = append(vertices, nx)
-			vertices = append(vertices, ny)
-			vertices = append(vertices, nz)
+			lat, lon := point(i, j)
+
+			// vertex position (x, y, z) on the ellipsoid, scaled by radius
+			h := 0.0
+			if ground {
+				h = groundHeight(lat, lon)
+			}
+			x, y, z := latLonToVertex(lat, lon, h)
+			vertices = append(vertices, x*float32(radius), y*float32(radius), z*float32(radius))
+
+			// ellipsoid normal (nx, ny, nz), which points along the geodetic latitude
+			latRad, lonRad := lat*(math.Pi/180), lon*(math.Pi/180)
+			vertices = append(vertices,
+				float32(math.Cos(latRad)*math.Cos(lonRad)),
+				float32(math.Cos(latRad)*math.Sin(lonRad)),
+				float32(math.Sin(latRad)))
 
 			// vertex tex coord (s, t) range between [0, 1]
-			s = float32(j) / float32(sectorCount)
-			t = float32(i) / float32(stackCount)
-			vertices = append(vertices, s)
-			vertices = append(vertices, t)
+			vertices = append(vertices, float32(j)/float32(sectorCount), float32(i)/float32(stackCount))
 		}
 	}
 
-	var k1, k2 int
 	var indices []uint32
-
 	for i := 0; i < stackCount; i++ {
-		k1 = i * (sectorCount + 1) // beginning of current stack
-		k2 = k1 + sectorCount + 1  // beginning of next stack
+		k1 := i * (sectorCount + 1) // beginning of current stack
+		k2 := k1 + sectorCount + 1  // beginning of next stack
 
 		for j := 0; j < sectorCount; j++ {
-			// 2 triangles per sector excluding 1st and last stacks
-			if i != 0 {
-				indices = append(indices, uint32(k1))
-				indices = append(indices, uint32(k2))
-				indices = append(indices, uint32(k1+1))
+			// 2 triangles per sector, excluding 1st and last stacks at the poles
+			if i != 0 || !poles {
+				indices = append(indices, uint32(k1), uint32(k2), uint32(k1+1))
 			}
-
-			if i != (stackCount - 1) {
-				indices = append(indices, uint32(k1+1))
-				indices = append(indices, uint32(k2))
-				indices = append(indices, uint32(k2+1))
+			if i != stackCount-1 || !poles {
+				indices = append(indices, uint32(k1+1), uint32(k2), uint32(k2+1))
 			}
 			k1++
 			k2++
@@ -79,17 +91,33 @@ func generateSphere(sectorCount int, stackCount int, radius float64) ([]float32,
 	return vertices, indices
 }
 
+// converts geodetic latitude, longitude (degrees) and height above the WGS-84 ellipsoid (m)
+// to earth centered, earth fixed coordinates in units of the equatorial radius
 func latLonToVertex(lat float64, lon float64, h float64) (float32, float32, float32) {
+	p := geodeticToECEF(lat, lon, h)
+	return float32(p[0]) / a, float32(p[1]) / a, float32(p[2]) / a
+}
+
+// converts geodetic latitude, longitude (degrees) and height above the WGS-84 ellipsoid (m)
+// to earth centered, earth fixed coordinates in m
+func geodeticToECEF(lat float64, lon float64, h float64) [3]float64 {
 	latRad := lat * (math.Pi / 180)
 	lonRad := lon * (math.Pi / 180)
 
-	R := h + a
+	// prime vertical radius of curvature
+	N := a / math.Sqrt(1-e2*math.Sin(latRad)*math.Sin(latRad))
 
-	X := R * math.Cos(latRad) * math.Cos(lonRad)
+	X := (N + h) * math.Cos(latRad) * math.Cos(lonRad)
 
-	Y := R * math.Cos(latRad) * math.Sin(lonRad)
+	Y := (N + h) * math.Cos(latRad) * math.Sin(lonRad)
 
-	Z := R * math.Sin(latRad)
+	Z := (N*(1-e2) + h) * math.Sin(latRad)
+
+	return [3]float64{X, Y, Z}
+}
 
-	return float32(X) / a, float32(Y) / a, float32(Z) / a
+// returns p with its component along the polar axis stretched by a/polarRadius, which maps the
+// ellipsoid onto a sphere of the equatorial radius. pole is the unit polar axis in the space of p
+func ellipsoidToSphere(p mgl32.Vec3, pole mgl32.Vec3) mgl32.Vec3 {
+	return p.Add(pole.Mul(p.Dot(pole) * float32(a/polarRadius-1)))
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeodeticToECEF(t *testing.T) {
+	tests := []struct {
+		name        string
+		lat, lon, h float64
+		want        [3]float64
+		tolerance   float64
+	}{
+		{"equator at the prime meridian", 0, 0, 0, [3]float64{a, 0, 0}, 1e-6},
+		{"equator at 90 east", 0, 90, 0, [3]float64{0, a, 0}, 1e-6},
+		{"north pole", 90, 0, 0, [3]float64{0, 0, polarRadius}, 1e-6},
+		{"south pole", -90, 0, 0, [3]float64{0, 0, -polarRadius}, 1e-6},
+		{"height at the equator", 0, 180, 1000, [3]float64{-(a + 1000), 0, 0}, 1e-6},
+		// reference values for WGS-84
+		{"45 north 45 east at 1000 m", 45, 45, 1000, [3]float64{3194919.145, 3194919.145, 4488055.516}, 1e-3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := geodeticToECEF(tt.lat, tt.lon, tt.h)
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > tt.tolerance {
+					t.Errorf("geodeticToECEF(%v, %v, %v) = %v, want %v", tt.lat, tt.lon, tt.h, got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestLatLonToVertex(t *testing.T) {
+	tests := []struct {
+		name        string
+		lat, lon, h float64
+		want        [3]float32
+	}{
+		{"equator at the prime meridian", 0, 0, 0, [3]float32{1, 0, 0}},
+		{"north pole", 90, 0, 0, [3]float32{0, 0, float32(polarRadius / a)}},
+		{"height in earth radii", 0, 0, a, [3]float32{2, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y, z := latLonToVertex(tt.lat, tt.lon, tt.h)
+			got := [3]float32{x, y, z}
+			for i := range got {
+				if math.Abs(float64(got[i]-tt.want[i])) > 1e-6 {
+					t.Errorf("latLonToVertex(%v, %v, %v) = %v, want %v", tt.lat, tt.lon, tt.h, got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// mean distance from the earth to the sun in earth radii (1 AU)
+const sunDistance = 149597870700.0 / a
+
+// returns the unit direction to the sun in the ECI frame (mean equator and equinox of date) for a UTC time,
+// from the low precision solar ephemeris of the Astronomical Almanac (about 0.01 degrees until 2050)
+func sunDirectionECI(t time.Time) mgl32.Vec3 {
+	jd := float64(t.UnixNano())/(86400*1e9) + 2440587.5
+	n := jd - 2451545.0 // days since J2000
+
+	rad := math.Pi / 180
+	L := 280.460 + 0.9856474*n // mean longitude
+	g := 357.528 + 0.9856003*n // mean anomaly
+	lambda := L + 1.915*math.Sin(g*rad) + 0.020*math.Sin(2*g*rad)
+	epsilon := 23.439 - 0.0000004*n // obliquity of the ecliptic
+
+	return mgl32.Vec3{
+		float32(math.Cos(lambda * rad)),
+		float32(math.Cos(epsilon*rad) * math.Sin(lambda*rad)),
+		float32(math.Sin(epsilon*rad) * math.Sin(lambda*rad)),
+	}
+}
+
+// returns the unit direction to the sun in the earth fixed (ECEF) frame for a UTC time
+func sunDirectionECEF(t time.Time) mgl32.Vec3 {
+	return eciToECEF(sunDirectionECI(t), t)
+}
+
+// returns the world position of the sun for the simulation time, given the earth model matrix
+func sunPosition(model mgl32.Mat4) mgl32.Vec3 {
+	return model.Mul4x1(sunDirectionECEF(simTime).Vec4(0)).Vec3().Mul(float32(sunDistance))
+}
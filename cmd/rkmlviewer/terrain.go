@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// value of void samples in SRTM .hgt files
+	hgtVoid = -32768
+
+	// cells per side of a tile mesh when terrain is loaded, and the deepest zoom level the globe is split to for it
+	terrainTileCells = 32
+	terrainMaxZoom   = 13
+
+	// most samples a GeoTIFF may have (1 GB of heights), larger sizes are taken for a corrupt header
+	geoTIFFMaxSamples = 1 << 28
+)
+
+// demGrid is a regular grid of elevation samples in meters, NaN where void
+type demGrid struct {
+	west, north float64 // position of the first (north west) sample in degrees
+	dLon, dLat  float64 // sample spacing in degrees
+	width       int
+	height      int
+	heights     []float32 // rows from north to south
+}
+
+var (
+	// loaded elevation data, searched in order
+	demGrids []demGrid
+
+	// factor terrain heights are multiplied by when drawn and when clamping features to the ground
+	terrainExaggeration = 1.0
+)
+
+// matches SRTM file names, e.g. N37W122.hgt for the cell from 37N 122W to 38N 121W
+var hgtName = regexp.MustCompile(`(?i)^([NS])(\d{2})([EW])(\d{3})\.hgt$`)
+
+// loads the elevation data of a .hgt or GeoTIFF file, or of every such file in a directory tree.
+// files that can't be read are reported as warnings, it is an error if none is loaded
+func loadTerrain(path string) error {
+	files := []string{}
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".hgt", ".tif", ".tiff":
+			if !info.IsDir() {
+				files = append(files, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		var g demGrid
+		var err error
+		if strings.ToLower(filepath.Ext(f)) == ".hgt" {
+			g, err = loadHGT(f)
+		} else {
+			g, err = loadGeoTIFF(f)
+		}
+		if err != nil {
+			addWarning(fmt.Sprint("Elevation data could not be loaded: ", err))
+			continue
+		}
+		demGrids = append(demGrids, g)
+	}
+	if len(demGrids) == 0 {
+		return fmt.Errorf("no .hgt or GeoTIFF elevation data in %s", path)
+	}
+	return nil
+}
+
+// reads an SRTM .hgt file: a square grid of big endian 16 bit heights covering the one degree cell in its name
+func loadHGT(path string) (demGrid, error) {
+	m := hgtName.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return demGrid{}, fmt.Errorf("%s: name is not like N37W122.hgt", path)
+	}
+	lat, _ := strconv.Atoi(m[2])
+	lon, _ := strconv.Atoi(m[4])
+	if strings.ToUpper(m[1]) == "S" {
+		lat = -lat
+	}
+	if strings.ToUpper(m[3]) == "W" {
+		lon = -lon
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return demGrid{}, err
+	}
+	n := int(math.Sqrt(float64(len(data) / 2)))
+	if n < 2 || n*n*2 != len(data) {
+		return demGrid{}, fmt.Errorf("%s: not a square grid of 16 bit samples", path)
+	}
+
+	g := demGrid{
+		west: float64(lon), north: float64(lat + 1),
+		dLon: 1 / float64(n-1), dLat: 1 / float64(n-1),
+		width: n, height: n,
+		heights: make([]float32, n*n),
+	}
+	for i := range g.heights {
+		v := int16(binary.BigEndian.Uint16(data[2*i:]))
+		if v == hgtVoid {
+			g.heights[i] = float32(math.NaN())
+		} else {
+			g.heights[i] = float32(v)
+		}
+	}
+	return g, nil
+}
+
+// tiffField is one entry of a TIFF image file directory
+type tiffField struct {
+	typ   uint16
+	count uint32
+	data  []byte // the value bytes, read from the offset if they don't fit in the entry
+}
+
+// reads a single band GeoTIFF in geographic (lat/lon) coordinates. supports strips and tiles, no or deflate
+// compression, horizontal differencing, and 8 to 64 bit integer or floating point samples
+func loadGeoTIFF(path string) (demGrid, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return demGrid{}, err
+	}
+	g, err := decodeGeoTIFF(data)
+	if err != nil {
+		return demGrid{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return g, nil
+}
+
+func decodeGeoTIFF(data []byte) (demGrid, error) {
+	if len(data) < 8 {
+		return demGrid{}, fmt.Errorf("not a TIFF file")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return demGrid{}, fmt.Errorf("not a TIFF file")
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return demGrid{}, fmt.Errorf("not a classic TIFF file (BigTIFF is not supported)")
+	}
+
+	// read the first image file directory
+	typeSizes := map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 6: 1, 7: 1, 8: 2, 9: 4, 11: 4, 12: 8}
+	offset := order.Uint32(data[4:])
+	if uint64(offset)+2 > uint64(len(data)) {
+		return demGrid{}, fmt.Errorf("truncated file")
+	}
+	count := int(order.Uint16(data[offset:]))
+	fields := make(map[uint16]tiffField)
+	for i := 0; i < count; i++ {
+		e := offset + 2 + uint32(i)*12
+		if uint64(e)+12 > uint64(len(data)) {
+			return demGrid{}, fmt.Errorf("truncated file")
+		}
+		f := tiffField{typ: order.Uint16(data[e+2:]), count: order.Uint32(data[e+4:])}
+		typeSize, ok := typeSizes[f.typ]
+		if !ok {
+			// fields of unknown types are skipped, as readers must
+			continue
+		}
+		if uint64(typeSize)*uint64(f.count) > uint64(len(data)) {
+			return demGrid{}, fmt.Errorf("field %d is larger than the file", order.Uint16(data[e:]))
+		}
+		size := typeSize * f.count
+		if size <= 4 {
+			f.data = data[e+8 : e+8+size]
+		} else {
+			at := order.Uint32(data[e+8:])
+			if uint64(at)+uint64(size) > uint64(len(data)) {
+				return demGrid{}, fmt.Errorf("truncated file")
+			}
+			f.data = data[at : at+size]
+		}
+		fields[order.Uint16(data[e:])] = f
+	}
+
+	// returns the values of a numeric field, or def if it is missing
+	values := func(tag uint16, def ...float64) []float64 {
+		f, ok := fields[tag]
+		if !ok {
+			return def
+		}
+		v := make([]float64, 0, f.count)
+		for i := uint32(0); i < f.count; i++ {
+			switch f.typ {
+			case 1, 7:
+				v = append(v, float64(f.data[i]))
+			case 6:
+				v = append(v, float64(int8(f.data[i])))
+			case 3:
+				v = append(v, float64(order.Uint16(f.data[2*i:])))
+			case 8:
+				v = append(v, float64(int16(order.Uint16(f.data[2*i:]))))
+			case 4:
+				v = append(v, float64(order.Uint32(f.data[4*i:])))
+			case 9:
+				v = append(v, float64(int32(order.Uint32(f.data[4*i:]))))
+			case 11:
+				v = append(v, float64(math.Float32frombits(order.Uint32(f.data[4*i:]))))
+			case 12:
+				v = append(v, math.Float64frombits(order.Uint64(f.data[8*i:])))
+			}
+		}
+		return v
+	}
+	// returns the first value of a numeric field, or def if it is missing or empty
+	value := func(tag uint16, def float64) float64 {
+		if v := values(tag); len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+
+	width := int(value(256, 0))
+	height := int(value(257, 0))
+	bits := int(value(258, 1))
+	compression := int(value(259, 1))
+	samples := int(value(277, 1))
+	predictor := int(value(317, 1))
+	format := int(value(339, 1))
+	if width <= 0 || height <= 0 {
+		return demGrid{}, fmt.Errorf("no image size")
+	}
+	if width > geoTIFFMaxSamples/height {
+		return demGrid{}, fmt.Errorf("image of %dx%d samples is too large", width, height)
+	}
+	if samples != 1 {
+		return demGrid{}, fmt.Errorf("%d bands, only single band elevation is supported", samples)
+	}
+	if compression != 1 && compression != 8 && compression != 32946 {
+		return demGrid{}, fmt.Errorf("compression %d is not supported, only none and deflate", compression)
+	}
+	if predictor != 1 && (predictor != 2 || format == 3) {
+		return demGrid{}, fmt.Errorf("predictor %d is not supported", predictor)
+	}
+	if bits != 8 && bits != 16 && bits != 32 && bits != 64 || format == 3 && bits < 32 {
+		return demGrid{}, fmt.Errorf("%d bit samples are not supported", bits)
+	}
+
+	// georeferencing: the model position of a pixel, the pixel size and whether positions are pixel corners
+	tiepoint := values(33922)
+	scale := values(33550)
+	if len(tiepoint) < 6 || len(scale) < 2 {
+		return demGrid{}, fmt.Errorf("no GeoTIFF tie point and pixel scale")
+	}
+	for _, v := range append(tiepoint[:6:6], scale[:2]...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return demGrid{}, fmt.Errorf("bad GeoTIFF tie point or pixel scale")
+		}
+	}
+	if scale[0] <= 0 || scale[1] <= 0 {
+		return demGrid{}, fmt.Errorf("bad GeoTIFF tie point or pixel scale")
+	}
+	pixelIsArea := true
+	if keys := values(34735); len(keys) >= 4 {
+		for i := 4; i+3 < len(keys); i += 4 {
+			switch {
+			case keys[i] == 1024 && keys[i+3] != 2:
+				return demGrid{}, fmt.Errorf("not in geographic (lat/lon) coordinates")
+			case keys[i] == 1025:
+				pixelIsArea = keys[i+3] != 2
+			}
+		}
+	}
+
+	g := demGrid{
+		west:  tiepoint[3] - tiepoint[0]*scale[0],
+		north: tiepoint[4] + tiepoint[1]*scale[1],
+		dLon:  scale[0], dLat: scale[1],
+		width: width, height: height,
+		heights: make([]float32, width*height),
+	}
+	if pixelIsArea {
+		g.west += scale[0] / 2
+		g.north -= scale[1] / 2
+	}
+	for i := range g.heights {
+		g.heights[i] = float32(math.NaN())
+	}
+
+	noData := math.NaN()
+	if f, ok := fields[42113]; ok {
+		if v, err := strconv.ParseFloat(strings.Trim(string(f.data), "\x00 "), 64); err == nil {
+			noData = v
+		}
+	}
+
+	// the image is stored in chunks, either strips of full rows or tiles
+	chunkWidth, chunkHeight := width, int(value(278, float64(height)))
+	offsets, counts := values(273), values(279)
+	if _, ok := fields[322]; ok {
+		chunkWidth, chunkHeight = int(value(322, 0)), int(value(323, 0))
+		offsets, counts = values(324), values(325)
+	}
+	if len(offsets) != len(counts) || chunkWidth <= 0 || chunkHeight <= 0 || chunkWidth > geoTIFFMaxSamples/chunkHeight {
+		return demGrid{}, fmt.Errorf("bad strip or tile layout")
+	}
+	across := (width + chunkWidth - 1) / chunkWidth
+	bytesPerSample := bits / 8
+
+	for c := range offsets {
+		if offsets[c] < 0 || counts[c] < 0 || offsets[c]+counts[c] > float64(len(data)) {
+			return demGrid{}, fmt.Errorf("truncated file")
+		}
+		start, size := int(offsets[c]), int(counts[c])
+		chunk := data[start : start+size]
+		if compression != 1 {
+			r, err := zlib.NewReader(bytes.NewReader(chunk))
+			if err != nil {
+				return demGrid{}, err
+			}
+			// a chunk never holds more than its samples, however well it compresses
+			chunk, err = ioutil.ReadAll(io.LimitReader(r, int64(chunkWidth*chunkHeight*bytesPerSample)))
+			if err != nil {
+				return demGrid{}, err
+			}
+		}
+
+		x0, y0 := (c%across)*chunkWidth, (c/across)*chunkHeight
+		rows := len(chunk) / (chunkWidth * bytesPerSample)
+		for row := 0; row < rows && row < chunkHeight; row++ {
+			var prev uint64
+			for col := 0; col < chunkWidth; col++ {
+				b := chunk[(row*chunkWidth+col)*bytesPerSample:]
+				var raw uint64
+				switch bits {
+				case 8:
+					raw = uint64(b[0])
+				case 16:
+					raw = uint64(order.Uint16(b))
+				case 32:
+					raw = uint64(order.Uint32(b))
+				case 64:
+					raw = order.Uint64(b)
+				}
+				if predictor == 2 {
+					// horizontal differencing: samples are stored as the difference to the previous one
+					raw = (raw + prev) & (1<<uint(bits) - 1)
+					prev = raw
+				}
+
+				var v float64
+				switch {
+				case format == 3 && bits == 32:
+					v = float64(math.Float32frombits(uint32(raw)))
+				case format == 3:
+					v = math.Float64frombits(raw)
+				case format == 2:
+					// sign extend
+					v = float64(int64(raw<<uint(64-bits)) >> uint(64-bits))
+				default:
+					v = float64(raw)
+				}
+
+				x, y := x0+col, y0+row
+				if x >= width || y >= height || v == noData || math.IsNaN(v) || format == 2 && bits == 16 && v == hgtVoid {
+					continue
+				}
+				g.heights[y*width+x] = float32(v)
+			}
+		}
+	}
+	return g, nil
+}
+
+// returns the elevation in meters of the grid at a position by bilinear interpolation of the valid
+// samples around it, false if the position is outside the grid or all of them are void
+func (g *demGrid) elevation(lat float64, lon float64) (float64, bool) {
+	fx := (lon - g.west) / g.dLon
+	fy := (g.north - lat) / g.dLat
+	if fx < 0 || fy < 0 || fx > float64(g.width-1) || fy > float64(g.height-1) {
+		return 0, false
+	}
+	x0, y0 := int(fx), int(fy)
+	x1, y1 := x0+1, y0+1
+	if x1 >= g.width {
+		x1 = x0
+	}
+	if y1 >= g.height {
+		y1 = y0
+	}
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	sum, weights := 0.0, 0.0
+	for _, s := range []struct {
+		x, y int
+		w    float64
+	}{{x0, y0, (1 - tx) * (1 - ty)}, {x1, y0, tx * (1 - ty)}, {x0, y1, (1 - tx) * ty}, {x1, y1, tx * ty}} {
+		h := float64(g.heights[s.y*g.width+s.x])
+		if !math.IsNaN(h) {
+			sum += h * s.w
+			weights += s.w
+		}
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+// returns the terrain elevation in meters at a geodetic position, 0 where there is no elevation data
+func terrainHeight(lat float64, lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	lon -= 180
+
+	for i := range demGrids {
+		if h, ok := demGrids[i].elevation(lat, lon); ok {
+			return h
+		}
+	}
+	return 0
+}
+
+// returns the height of the drawn ground in meters at a geodetic position, the terrain height exaggerated
+func groundHeight(lat float64, lon float64) float64 {
+	if len(demGrids) == 0 {
+		return 0
+	}
+	return terrainHeight(lat, lon) * terrainExaggeration
+}
+
+// returns the height in meters above the ellipsoid of a KML altitude in an altitude mode. positions clamped
+// to or relative to the ground follow the terrain, others (absolute or unset) keep their altitude
+func altitudeAboveEllipsoid(lat float64, lon float64, alt float64, mode string) float64 {
+	switch mode {
+	case "clampToGround":
+		return groundHeight(lat, lon)
+	case "relativeToGround":
+		return groundHeight(lat, lon) + alt
+	}
+	return alt
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// tiffEntry is a field of a test TIFF file: its type, count and value bytes as they are written
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func shorts(tag uint16, v ...uint16) tiffEntry {
+	b := make([]byte, 2*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint16(b[2*i:], x)
+	}
+	return tiffEntry{tag, 3, uint32(len(v)), b}
+}
+
+func longs(tag uint16, typ uint16, v ...uint32) tiffEntry {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], x)
+	}
+	return tiffEntry{tag, typ, uint32(len(v)), b}
+}
+
+func doubles(tag uint16, v ...float64) tiffEntry {
+	b := make([]byte, 8*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(x))
+	}
+	return tiffEntry{tag, 12, uint32(len(v)), b}
+}
+
+// returns a little endian TIFF file with the pixels at offset 8, followed by the image file directory
+// and the values that don't fit in their entries. a value of 4 bytes or less is written in its entry
+func buildTIFF(pixels []byte, entries []tiffEntry) []byte {
+	data := append([]byte("II\x2a\x00\x00\x00\x00\x00"), pixels...)
+	ifd := len(data)
+	binary.LittleEndian.PutUint32(data[4:], uint32(ifd))
+
+	data = append(data, make([]byte, 2+12*len(entries)+4)...)
+	binary.LittleEndian.PutUint16(data[ifd:], uint16(len(entries)))
+	for i, e := range entries {
+		at := ifd + 2 + 12*i
+		binary.LittleEndian.PutUint16(data[at:], e.tag)
+		binary.LittleEndian.PutUint16(data[at+2:], e.typ)
+		binary.LittleEndian.PutUint32(data[at+4:], e.count)
+		if len(e.value) <= 4 {
+			copy(data[at+8:], e.value)
+			continue
+		}
+		binary.LittleEndian.PutUint32(data[at+8:], uint32(len(data)))
+		data = append(data, e.value...)
+	}
+	return data
+}
+
+// returns the entries of a 2x2 signed 16 bit GeoTIFF with its pixels at offset 8, with some entries replaced
+// or added (by tag) and some removed (a tiffEntry with only a tag)
+func testGeoTIFFEntries(changes ...tiffEntry) []tiffEntry {
+	entries := []tiffEntry{
+		shorts(256, 2), shorts(257, 2), shorts(258, 16), shorts(277, 1), shorts(339, 2),
+		longs(273, 4, 8), longs(279, 4, 8), shorts(278, 2),
+		doubles(33550, 1, 1, 0), doubles(33922, 0, 0, 0, 10, 20, 0),
+	}
+	for _, c := range changes {
+		found := false
+		for i := range entries {
+			if entries[i].tag == c.tag {
+				entries[i], found = c, true
+			}
+		}
+		if !found {
+			entries = append(entries, c)
+		}
+	}
+	kept := []tiffEntry{}
+	for _, e := range entries {
+		if e.typ != 0 {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+var testGeoTIFFPixels = []byte{1, 0, 2, 0, 3, 0, 0x00, 0x80}
+
+func TestDecodeGeoTIFF(t *testing.T) {
+	g, err := decodeGeoTIFF(buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries()))
+	if err != nil {
+		t.Fatalf("decodeGeoTIFF() error = %v", err)
+	}
+	if g.width != 2 || g.height != 2 || g.west != 10.5 || g.north != 19.5 || g.dLon != 1 || g.dLat != 1 {
+		t.Errorf("decodeGeoTIFF() grid = %dx%d at %v, %v by %v, %v, want 2x2 at 10.5, 19.5 by 1, 1",
+			g.width, g.height, g.west, g.north, g.dLon, g.dLat)
+	}
+	if g.heights[0] != 1 || g.heights[1] != 2 || g.heights[2] != 3 || !math.IsNaN(float64(g.heights[3])) {
+		t.Errorf("decodeGeoTIFF() heights = %v, want [1 2 3 NaN]", g.heights)
+	}
+}
+
+func TestDecodeGeoTIFFMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte("II\x2a\x00")},
+		{"not a tiff", []byte("PK\x03\x04\x00\x00\x00\x00")},
+		{"directory past the end", []byte("II\x2a\x00\xff\x00\x00\x00")},
+		{"truncated directory", []byte("II\x2a\x00\x08\x00\x00\x00\x05\x00")},
+		{"no fields", buildTIFF(nil, nil)},
+		{"empty width", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{256, 3, 0, nil}))},
+		{"empty bits per sample", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{258, 3, 0, nil}))},
+		{"width of unknown type", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{256, 99, 1, []byte{2, 0}}))},
+		{"count overflowing the size", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{33922, 12, 1 << 29, nil}))},
+		{"count past the end", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{273, 4, 1000, []byte{8, 0, 0, 0}}))},
+		{"huge image", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(longs(256, 4, 1<<31), longs(257, 4, 1<<31)))},
+		{"tile width without tile length", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(shorts(322, 16), longs(324, 4, 8), longs(325, 4, 8)))},
+		{"empty tile length", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(shorts(322, 16), tiffEntry{323, 3, 0, nil}))},
+		{"strip offsets without counts", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{tag: 279}))},
+		{"strip past the end", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(longs(273, 4, 1<<30)))},
+		{"negative strip offset", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(longs(273, 9, 0xfffffff8)))},
+		{"corrupt deflate strip", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(shorts(259, 8)))},
+		{"no tie point", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(tiffEntry{tag: 33922}))},
+		{"short tie point", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(doubles(33922, 0, 0, 0)))},
+		{"zero pixel scale", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(doubles(33550, 0, 1, 0)))},
+		{"infinite tie point", buildTIFF(testGeoTIFFPixels, testGeoTIFFEntries(doubles(33922, 0, 0, 0, math.Inf(1), 20, 0)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeGeoTIFF(tt.data); err == nil {
+				t.Errorf("decodeGeoTIFF() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -6,28 +6,190 @@ import (
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+)
+
+const (
+	// size of the land/ocean texture made from the specular map when the diffuse texture is missing
+	fallbackWidth  = 2048
+	fallbackHeight = 1024
+
+	// with -compress, textures wider than this are uploaded in a compressed format
+	compressWidth = 8192
+)
+
+// how the pixels of a texture are used, which decides how they are stored
+type textureFormat int
+
+const (
+	// color image, stored as sRGB with -srgb
+	colorRGB textureFormat = iota
+	// color image with transparency, stored as sRGB with -srgb
+	colorRGBA
+	// masks and other values that aren't colors, always stored linear
+	dataRGB
+	// one channel mask, the pixels are one byte each instead of RGBA
+	dataGray
+)
+
+// minification filters selected with -filter
+var textureFilters = map[string]int32{
+	"bilinear":  gl.LINEAR_MIPMAP_NEAREST,
+	"trilinear": gl.LINEAR_MIPMAP_LINEAR,
+}
+
+var (
+	// colors of the fallback earth texture
+	oceanColor = [4]uint8{18, 44, 88, 255}
+	landColor  = [4]uint8{78, 96, 56, 255}
+
+	// minification filter of all textures, one of textureFilters
+	textureFilter int32 = gl.LINEAR_MIPMAP_LINEAR
+
+	// anisotropic filtering asked for with -anisotropy, 1 turns it off
+	textureAnisotropy float32 = 8
+
+	// largest anisotropy the driver supports, 0 if it has no anisotropic filtering
+	maxAnisotropy float32
+
+	// color textures are stored as sRGB, so they are filtered in linear light, and the
+	// framebuffer converts the shaded colors back to sRGB
+	srgbTextures = false
+
+	// large textures are uploaded in a compressed format chosen by the driver
+	compressTextures = false
 )
 
-func loadImage(filepath string) ([]uint8, int32, int32) {
+// looks up anisotropic filtering support and turns on the sRGB framebuffer, called once the context exists
+func initTextureFiltering() {
+	var count int32
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &count)
+	for i := uint32(0); i < uint32(count); i++ {
+		name := gl.GoStr(gl.GetStringi(gl.EXTENSIONS, i))
+		if name == "GL_EXT_texture_filter_anisotropic" || name == "GL_ARB_texture_filter_anisotropic" {
+			gl.GetFloatv(gl.MAX_TEXTURE_MAX_ANISOTROPY, &maxAnisotropy)
+			break
+		}
+	}
+	if textureAnisotropy > 1 && maxAnisotropy == 0 {
+		addWarning("Anisotropic filtering is not supported by the graphics driver")
+	}
+
+	if srgbTextures {
+		gl.Enable(gl.FRAMEBUFFER_SRGB)
+	}
+}
+
+// returns the OpenGL internal format a texture is stored with
+func internalFormat(format textureFormat, compressed bool) int32 {
+	switch {
+	case format == colorRGB && srgbTextures && compressed:
+		return gl.COMPRESSED_SRGB
+	case format == colorRGB && srgbTextures:
+		return gl.SRGB8
+	case format == colorRGBA && srgbTextures && compressed:
+		return gl.COMPRESSED_SRGB_ALPHA
+	case format == colorRGBA && srgbTextures:
+		return gl.SRGB8_ALPHA8
+	case format == colorRGBA && compressed:
+		return gl.COMPRESSED_RGBA
+	case format == colorRGBA:
+		return gl.RGBA8
+	case format == dataGray && compressed:
+		return gl.COMPRESSED_RED_RGTC1
+	case format == dataGray:
+		return gl.R8
+	case compressed:
+		return gl.COMPRESSED_RGB
+	}
+	return gl.RGB8
+}
+
+// loads an asset image as RGBA pixels, returns its size
+func loadImage(filepath string) ([]uint8, int32, int32, error) {
 	// You can register another format here
 	image.RegisterFormat("png", "png", png.Decode, png.DecodeConfig)
 	image.RegisterFormat("jpeg", "jpeg", jpeg.Decode, jpeg.DecodeConfig)
 
-	file, err := os.Open(filepath)
+	file, err := openAsset(filepath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 
+	defer file.Close()
+
+	rgba, err := decodeImage(file)
 	if err != nil {
-		fmt.Println("Error: File could not be opened")
-		os.Exit(1)
+		return nil, 0, 0, fmt.Errorf("image %s could not be decoded: %v", filepath, err)
 	}
 
+	rect := rgba.Bounds()
+
+	return rgba.Pix, int32(rect.Max.X - rect.Min.X), int32(rect.Max.Y - rect.Min.Y), nil
+}
+
+// loads an asset image as one byte per pixel gray levels, returns its size. large masks are kept
+// in a quarter of the memory of loadImage
+func loadGrayImage(filepath string) ([]uint8, int32, int32, error) {
+	file, err := openAsset(filepath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	defer file.Close()
 
 	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("image %s could not be decoded: %v", filepath, err)
+	}
 
+	rect := img.Bounds()
+	gray, ok := img.(*image.Gray)
+	if !ok || gray.Stride != rect.Dx() {
+		gray = image.NewGray(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(gray, gray.Bounds(), img, rect.Min, draw.Src)
+	}
+
+	return gray.Pix, int32(rect.Dx()), int32(rect.Dy()), nil
+}
+
+// returns the pixels of a 1x1 image of one color
+func solidPixels(c [4]uint8) []uint8 {
+	return []uint8{c[0], c[1], c[2], c[3]}
+}
+
+// returns a land/ocean colored earth texture made from the specular map (white over water),
+// or a plain ocean texture if the specular map can't be loaded either
+func landOceanPixels(maskPath string) ([]uint8, int32, int32) {
+	mask, w, h, err := loadImage(maskPath)
 	if err != nil {
-		fmt.Println("Error: Image could not be decoded")
-		os.Exit(1)
+		return solidPixels(oceanColor), 1, 1
+	}
+
+	pixels := make([]uint8, fallbackWidth*fallbackHeight*4)
+	for y := 0; y < fallbackHeight; y++ {
+		for x := 0; x < fallbackWidth; x++ {
+			m := mask[((y*int(h)/fallbackHeight)*int(w)+x*int(w)/fallbackWidth)*4]
+			c := landColor
+			if m > 127 {
+				c = oceanColor
+			}
+			copy(pixels[(y*fallbackWidth+x)*4:], c[:])
+		}
+	}
+	return pixels, fallbackWidth, fallbackHeight
+}
+
+// decodes a png or jpeg image into RGBA pixels
+func decodeImage(r io.Reader) (*image.RGBA, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
 	}
 
 	// b := img.Bounds()
@@ -42,8 +204,44 @@ func loadImage(filepath string) ([]uint8, int32, int32) {
 	// }
 
 	rect := img.Bounds()
-	rgba := image.NewRGBA(rect)
-	draw.Draw(rgba, rect, img, rect.Min, draw.Src)
+	rgba := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, rect.Min, draw.Src)
+
+	return rgba, nil
+}
+
+// loads an image linked from the KML document (an <Icon> href) from the KMZ archive
+// or from a file next to the document. remote and missing images return false
+func loadLinkedImage(href string) (*image.RGBA, bool) {
+	if strings.Contains(href, "://") {
+		return nil, false
+	}
+
+	if kmzArchive != nil {
+		name := path.Clean(strings.TrimPrefix(href, "/"))
+		for _, f := range kmzArchive.File {
+			if f.Name != name {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, false
+			}
+			defer r.Close()
+			img, err := decodeImage(r)
+			return img, err == nil
+		}
+	}
 
-	return rgba.Pix, int32(rect.Max.X - rect.Min.X), int32(rect.Max.Y - rect.Min.Y)
+	p := href
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(visualOutputPath), p)
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	img, err := decodeImage(file)
+	return img, err == nil
 }
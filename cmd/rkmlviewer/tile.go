@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gl/gl/v3.2-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	_ "github.com/mattn/go-sqlite3" // sqlite driver for MBTiles
+)
+
+const (
+	// tiles drawn bigger than this many pixels are replaced by their four children
+	tileScreenSize = 256
+
+	// number of tile textures and meshes kept, least recently drawn ones are freed first
+	tileCacheSize = 512
+
+	// goroutines reading and decoding tiles, and decoded tiles turned into textures per frame
+	tileLoaders         = 4
+	tileUploadsPerFrame = 8
+
+	// largest cell of a tile mesh in degrees, so big tiles follow the curve of the globe
+	tileCellDegrees = 2.0
+	tileMinCells    = 4
+
+	// the earth model is drawn this much smaller (earth radii) under the tiles, so it doesn't show through them
+	tileGlobeSink = 0.0005
+)
+
+// tileSource reads encoded tile images (png or jpeg) by zoom level and XYZ column and row,
+// row 0 being at the north edge of the web mercator square
+type tileSource interface {
+	tile(z int, x int, y int) ([]byte, error)
+	maxZoom() int
+}
+
+// dirTileSource reads tiles from a {z}/{x}/{y}.png (or .jpg) directory tree
+type dirTileSource struct {
+	root string
+	tms  bool // rows are counted from the south edge
+	zoom int
+}
+
+// mbTilesSource reads tiles from an MBTiles file (sqlite, rows counted from the south edge)
+type mbTilesSource struct {
+	db   *sql.DB
+	zoom int
+}
+
+type tileKey struct {
+	z, x, y int
+}
+
+type tileState int
+
+const (
+	tileLoading tileState = iota
+	tileLoaded
+	tileMissing
+)
+
+// tileEntry is the texture of a tile, once it has been loaded
+type tileEntry struct {
+	state    tileState
+	texture  uint32
+	lastUsed int
+}
+
+// tileMesh is the patch of globe a tile is drawn on
+type tileMesh struct {
+	vertexArray   uint32
+	vertexBuffer  uint32
+	elementBuffer uint32
+	count         int32
+	lastUsed      int
+}
+
+// tileResult is a tile read and decoded by a loader
+type tileResult struct {
+	key tileKey
+	img *image.RGBA
+	err error
+}
+
+// tileLayer draws the globe in tiles over the earth model, picking the zoom level of each part of the globe
+// from its size on screen. the tiles carry the imagery of a source, if any, and the terrain relief, if loaded.
+// imagery is loaded in the background, until then the closest loaded ancestor is drawn
+type tileLayer struct {
+	source   tileSource // nil to draw the earth texture on the terrain
+	zoom     int        // deepest zoom level the globe is split to
+	entries  map[tileKey]*tileEntry
+	meshes   map[tileKey]*tileMesh
+	requests chan tileKey
+	results  chan tileResult
+	frame    int
+	warned   bool
+}
+
+// tiled imagery and terrain drawn over the earth model, nil if neither is configured
+var globeTiles *tileLayer
+
+// opens a tile directory or MBTiles file. tms is true if the rows of a directory count from the south
+func openTileSource(path string, tms bool) (tileSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		s := &dirTileSource{root: path, tms: tms, zoom: -1}
+		dirs, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			if z, err := strconv.Atoi(d.Name()); err == nil && d.IsDir() && z > s.zoom {
+				s.zoom = z
+			}
+		}
+		if s.zoom < 0 {
+			return nil, fmt.Errorf("no zoom level directories in %s", path)
+		}
+		return s, nil
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".mbtiles" {
+		return nil, fmt.Errorf("%s is neither a tile directory nor an .mbtiles file", path)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	var zoom sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(zoom_level) FROM tiles").Scan(&zoom); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if !zoom.Valid {
+		db.Close()
+		return nil, fmt.Errorf("%s has no tiles", path)
+	}
+	return &mbTilesSource{db: db, zoom: int(zoom.Int64)}, nil
+}
+
+func (s *dirTileSource) tile(z int, x int, y int) ([]byte, error) {
+	if s.tms {
+		y = 1<<uint(z) - 1 - y
+	}
+	base := filepath.Join(s.root, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y))
+	for _, ext := range []string{".png", ".jpg", ".jpeg"} {
+		data, err := ioutil.ReadFile(base + ext)
+		if err == nil || !os.IsNotExist(err) {
+			return data, err
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s *dirTileSource) maxZoom() int {
+	return s.zoom
+}
+
+func (s *mbTilesSource) tile(z int, x int, y int) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		z, x, 1<<uint(z)-1-y).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+func (s *mbTilesSource) maxZoom() int {
+	return s.zoom
+}
+
+// returns a tile layer for an imagery source, or nil for terrain only, and starts its loaders
+func newTileLayer(source tileSource) *tileLayer {
+	l := &tileLayer{
+		source:   source,
+		entries:  make(map[tileKey]*tileEntry),
+		meshes:   make(map[tileKey]*tileMesh),
+		requests: make(chan tileKey, tileCacheSize),
+		results:  make(chan tileResult, tileCacheSize),
+	}
+	if source != nil {
+		l.zoom = source.maxZoom()
+		for i := 0; i < tileLoaders; i++ {
+			go l.load()
+		}
+	}
+	if len(demGrids) > 0 && l.zoom < terrainMaxZoom {
+		l.zoom = terrainMaxZoom
+	}
+	return l
+}
+
+// reads and decodes requested tiles, run by each loader goroutine
+func (l *tileLayer) load() {
+	for k := range l.requests {
+		var img *image.RGBA
+		data, err := l.source.tile(k.z, k.x, k.y)
+		if err == nil {
+			img, err = decodeImage(bytes.NewReader(data))
+		}
+		l.results <- tileResult{key: k, img: img, err: err}
+	}
+}
+
+// returns the latitude in degrees of the north edge of tile row y of n rows
+func tileLatitude(y int, n int) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/float64(n)))) * (180 / math.Pi)
+}
+
+// returns the lat/lon box of a tile in degrees
+func tileBox(k tileKey) (north float64, south float64, east float64, west float64) {
+	n := 1 << uint(k.z)
+	west = float64(k.x)/float64(n)*360 - 180
+	return tileLatitude(k.y, n), tileLatitude(k.y+1, n), west + 360/float64(n), west
+}
+
+// returns the vertices and indices of the globe patch under a tile, in the globe vertex layout, raised by the terrain.
+// texture coordinates are those of the earth model, equirectangular over the whole globe (0 at 180 west and the
+// north pole); with imagery the fragment shader maps them to the tile texture
+func tileMeshVertices(k tileKey) ([]float32, []uint32) {
+	north, south, east, west := tileBox(k)
+	minCells := float64(tileMinCells)
+	if len(demGrids) > 0 {
+		minCells = terrainTileCells
+	}
+	cells := int(math.Max(minCells, math.Ceil((east-west)/tileCellDegrees)))
+
+	// positions first, the normals of the terrain come from the positions around each vertex
+	positions := make([]mgl32.Vec3, 0, (cells+1)*(cells+1))
+	for i := 0; i <= cells; i++ {
+		lat := north - (north-south)*float64(i)/float64(cells)
+		for j := 0; j <= cells; j++ {
+			lon := west + (east-west)*float64(j)/float64(cells)
+
+			// below sea level the tiles would sink under the earth model, so they stop at the ellipsoid
+			x, y, z := latLonToVertex(lat, lon, math.Max(0, groundHeight(lat, lon)))
+			positions = append(positions, mgl32.Vec3{x, y, z}.Mul(float32(radius)))
+		}
+	}
+	at := func(i int, j int) mgl32.Vec3 {
+		if i < 0 {
+			i = 0
+		} else if i > cells {
+			i = cells
+		}
+		if j < 0 {
+			j = 0
+		} else if j > cells {
+			j = cells
+		}
+		return positions[i*(cells+1)+j]
+	}
+
+	vertices := []float32{}
+	for i := 0; i <= cells; i++ {
+		lat := north - (north-south)*float64(i)/float64(cells)
+		for j := 0; j <= cells; j++ {
+			lon := west + (east-west)*float64(j)/float64(cells)
+			latRad, lonRad := lat*(math.Pi/180), lon*(math.Pi/180)
+
+			normal := mgl32.Vec3{
+				float32(math.Cos(latRad) * math.Cos(lonRad)),
+				float32(math.Cos(latRad) * math.Sin(lonRad)),
+				float32(math.Sin(latRad)),
+			}
+			if len(demGrids) > 0 {
+				// south cross east points up
+				n := at(i+1, j).Sub(at(i-1, j)).Cross(at(i, j+1).Sub(at(i, j-1)))
+				if n.Len() > 0 {
+					normal = n.Normalize()
+				}
+			}
+
+			s := (lon + 180) / 360
+			p := positions[i*(cells+1)+j]
+			vertices = append(vertices, p[0], p[1], p[2], normal[0], normal[1], normal[2], float32(s), float32((90-lat)/180))
+		}
+	}
+
+	indices := []uint32{}
+	for i := 0; i < cells; i++ {
+		k1 := i * (cells + 1)
+		k2 := k1 + cells + 1
+		for j := 0; j < cells; j++ {
+			indices = append(indices, uint32(k1), uint32(k2), uint32(k1+1))
+			indices = append(indices, uint32(k1+1), uint32(k2), uint32(k2+1))
+			k1++
+			k2++
+		}
+	}
+	return vertices, indices
+}
+
+// appends to out the tiles to draw under tile k, given the camera position in model space
+func (l *tileLayer) selectTiles(k tileKey, cam mgl32.Vec3, out *[]tileKey) {
+	north, south, east, west := tileBox(k)
+	midLat, midLon := (north+south)/2, (east+west)/2
+
+	// a tile is visible if a corner, edge middle or its center faces the camera
+	visible := k.z < 2
+	nearest := math.Inf(1)
+	for _, lat := range []float64{north, midLat, south} {
+		for _, lon := range []float64{west, midLon, east} {
+			x, y, z := latLonToVertex(lat, lon, 0)
+			p := mgl32.Vec3{x, y, z}
+			v := cam.Sub(p)
+			if v.Dot(p) > 0 {
+				visible = true
+			}
+			nearest = math.Min(nearest, float64(v.Len()))
+		}
+	}
+	if !visible {
+		return
+	}
+
+	size := (east - west) * (math.Pi / 180) * math.Cos(midLat*(math.Pi/180))
+	pixels := size / nearest * float64(height) / (float64(camera.fov) * (math.Pi / 180))
+	if k.z < l.zoom && pixels > tileScreenSize {
+		for _, c := range []tileKey{{k.z + 1, 2 * k.x, 2 * k.y}, {k.z + 1, 2*k.x + 1, 2 * k.y},
+			{k.z + 1, 2 * k.x, 2*k.y + 1}, {k.z + 1, 2*k.x + 1, 2*k.y + 1}} {
+			l.selectTiles(c, cam, out)
+		}
+		return
+	}
+	*out = append(*out, k)
+}
+
+// queues a tile for loading if it isn't loaded or queued yet. a full queue is retried next frame
+func (l *tileLayer) request(k tileKey) {
+	if _, ok := l.entries[k]; ok {
+		return
+	}
+	select {
+	case l.requests <- k:
+		l.entries[k] = &tileEntry{state: tileLoading, lastUsed: l.frame}
+	default:
+	}
+}
+
+// turns up to tileUploadsPerFrame loaded tiles into textures
+func (l *tileLayer) upload() {
+	for i := 0; i < tileUploadsPerFrame; i++ {
+		var r tileResult
+		select {
+		case r = <-l.results:
+		default:
+			return
+		}
+
+		e, ok := l.entries[r.key]
+		if !ok {
+			continue
+		}
+		if r.err != nil {
+			e.state = tileMissing
+			if !os.IsNotExist(r.err) && !l.warned {
+				addWarning(fmt.Sprintf("Tile %d/%d/%d could not be loaded: %v", r.key.z, r.key.x, r.key.y, r.err))
+				l.warned = true
+			}
+			continue
+		}
+
+		rect := r.img.Bounds()
+		e.texture = textureFromPixels(r.img.Pix, int32(rect.Dx()), int32(rect.Dy()), colorRGBA)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+		e.state = tileLoaded
+	}
+}
+
+// returns the loaded tile closest to k, k itself or one of its ancestors
+func (l *tileLayer) loadedAncestor(k tileKey) (tileKey, *tileEntry, bool) {
+	for z := k.z; z >= 0; z-- {
+		a := tileKey{z, k.x >> uint(k.z-z), k.y >> uint(k.z-z)}
+		if e, ok := l.entries[a]; ok && e.state == tileLoaded {
+			e.lastUsed = l.frame
+			return a, e, true
+		}
+	}
+	return tileKey{}, nil, false
+}
+
+// returns the mesh of a tile, building it the first time
+func (l *tileLayer) mesh(k tileKey) *tileMesh {
+	m, ok := l.meshes[k]
+	if !ok {
+		vertices, indices := tileMeshVertices(k)
+		m = &tileMesh{count: int32(len(indices))}
+
+		gl.GenVertexArrays(1, &m.vertexArray)
+		gl.GenBuffers(1, &m.vertexBuffer)
+		gl.GenBuffers(1, &m.elementBuffer)
+		gl.BindVertexArray(m.vertexArray)
+		gl.BindBuffer(gl.ARRAY_BUFFER, m.vertexBuffer)
+		gl.BufferData(gl.ARRAY_BUFFER, 4*len(vertices), gl.Ptr(vertices), gl.STATIC_DRAW)
+		gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, m.elementBuffer)
+		gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, 4*len(indices), gl.Ptr(indices), gl.STATIC_DRAW)
+		gl.VertexAttribPointer(0, 3, gl.FLOAT, false, 8*4, gl.PtrOffset(0))
+		gl.EnableVertexAttribArray(0)
+		gl.VertexAttribPointer(1, 3, gl.FLOAT, false, 8*4, gl.PtrOffset(3*4))
+		gl.EnableVertexAttribArray(1)
+		gl.VertexAttribPointer(2, 2, gl.FLOAT, false, 8*4, gl.PtrOffset(6*4))
+		gl.EnableVertexAttribArray(2)
+		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+		gl.BindVertexArray(0)
+
+		l.meshes[k] = m
+	}
+	m.lastUsed = l.frame
+	return m
+}
+
+// frees the least recently drawn textures and meshes beyond tileCacheSize. tiles still loading are kept
+func (l *tileLayer) evict() {
+	if len(l.entries) > tileCacheSize {
+		keys := []tileKey{}
+		for k, e := range l.entries {
+			if e.state != tileLoading && e.lastUsed < l.frame {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return l.entries[keys[i]].lastUsed < l.entries[keys[j]].lastUsed })
+		for _, k := range keys {
+			if len(l.entries) <= tileCacheSize {
+				break
+			}
+			if e := l.entries[k]; e.state == tileLoaded {
+				gl.DeleteTextures(1, &e.texture)
+			}
+			delete(l.entries, k)
+		}
+	}
+
+	if len(l.meshes) > tileCacheSize {
+		keys := []tileKey{}
+		for k, m := range l.meshes {
+			if m.lastUsed < l.frame {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return l.meshes[keys[i]].lastUsed < l.meshes[keys[j]].lastUsed })
+		for _, k := range keys {
+			if len(l.meshes) <= tileCacheSize {
+				break
+			}
+			m := l.meshes[k]
+			gl.DeleteVertexArrays(1, &m.vertexArray)
+			gl.DeleteBuffers(1, &m.vertexBuffer)
+			gl.DeleteBuffers(1, &m.elementBuffer)
+			delete(l.meshes, k)
+		}
+	}
+}
+
+// returns the model matrix to draw the earth model with: sunk under the tiles if there are any
+func globeModel(model mgl32.Mat4) mgl32.Mat4 {
+	if globeTiles == nil {
+		return model
+	}
+	s := float32(radius - tileGlobeSink)
+	return model.Mul4(mgl32.Scale3D(s, s, s))
+}
+
+// draws the tiles over the globe with the globe program, which must be in use with the earth textures bound.
+// model is the earth model matrix
+func drawTiles(program *shaderProgram, model mgl32.Mat4) {
+	l := globeTiles
+	if l == nil {
+		return
+	}
+	l.frame++
+	l.upload()
+	program.set("model", model)
+
+	// the sunk globe is closer than the depth buffer resolves when the camera is far away,
+	// so the tiles are also pulled towards the camera by a few depth steps
+	gl.Enable(gl.POLYGON_OFFSET_FILL)
+	gl.PolygonOffset(-1, -4)
+	defer gl.Disable(gl.POLYGON_OFFSET_FILL)
+
+	cam := model.Inv().Mul4x1(camera.Pos.Vec4(1)).Vec3()
+	selected := []tileKey{}
+	l.selectTiles(tileKey{0, 0, 0}, cam, &selected)
+
+	// terrain without imagery keeps the earth texture
+	if l.source == nil {
+		for _, k := range selected {
+			m := l.mesh(k)
+			gl.BindVertexArray(m.vertexArray)
+			gl.DrawElements(gl.TRIANGLES, m.count, gl.UNSIGNED_INT, gl.PtrOffset(0))
+		}
+		l.evict()
+		return
+	}
+
+	program.set("useTile", true)
+	gl.ActiveTexture(gl.TEXTURE1)
+	for _, k := range selected {
+		l.request(k)
+
+		// until the tile itself is loaded, the matching part of its closest loaded ancestor is drawn
+		a, e, ok := l.loadedAncestor(k)
+		if !ok {
+			continue
+		}
+		n := float32(int(1) << uint(a.z))
+		program.set("tileBounds", mgl32.Vec4{float32(a.x) / n, float32(a.y) / n, float32(a.x+1) / n, float32(a.y+1) / n})
+		gl.BindTexture(gl.TEXTURE_2D, e.texture)
+
+		m := l.mesh(k)
+		gl.BindVertexArray(m.vertexArray)
+		gl.DrawElements(gl.TRIANGLES, m.count, gl.UNSIGNED_INT, gl.PtrOffset(0))
+	}
+	program.set("useTile", false)
+
+	l.evict()
+}
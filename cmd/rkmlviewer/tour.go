@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/xml"
+	"strconv"
+	"sync"
+)
+
+// Tour is a gx:Tour, a named playlist of camera flights, waits and updates
+type Tour struct {
+	Name     string   `xml:"name"`
+	Playlist Playlist `xml:"Playlist"`
+}
+
+// Playlist keeps the tour primitives in document order
+type Playlist struct {
+	Items []TourItem `xml:",any"`
+}
+
+// TourItem is any tour primitive (gx:FlyTo, gx:Wait, gx:AnimatedUpdate, gx:TourControl), told apart by XMLName
+type TourItem struct {
+	XMLName   xml.Name
+	Duration  float64     `xml:"duration"`
+	FlyToMode string      `xml:"flyToMode"`
+	PlayMode  string      `xml:"playMode"`
+	LookAt    *LookAt     `xml:"LookAt"`
+	Camera    *CameraView `xml:"Camera"`
+	Update    *Update     `xml:"Update"`
+}
+
+// Update changes or deletes features by id
+type Update struct {
+	Changes []UpdateGroup `xml:"Change"`
+	Deletes []UpdateGroup `xml:"Delete"`
+}
+
+// UpdateGroup holds the features named in one <Change> or <Delete>
+type UpdateGroup struct {
+	Targets []UpdateTarget `xml:",any"`
+}
+
+// UpdateTarget is a feature referenced by targetId. only visibility is applied
+type UpdateTarget struct {
+	XMLName    xml.Name
+	TargetID   string `xml:"targetId,attr"`
+	Visibility *bool  `xml:"visibility"`
+}
+
+// visibilityChange shows or hides the feature with the given id
+type visibilityChange struct {
+	id      string
+	visible bool
+}
+
+// stores the tours in the document and the playback position
+var tourPlayer struct {
+	sync.Mutex
+	tours   []Tour
+	current int
+	index   int     // position in the playlist
+	elapsed float64 // seconds spent on the current item
+	started bool    // whether the current item has been started
+	playing bool
+
+	// set by the gui to end the tour's flight, which belongs to the render loop
+	stopFlight bool
+}
+
+// returns every tour in f and its subfolders
+func collectTours(f Folder) []Tour {
+	tours := append([]Tour{}, f.Tours...)
+	for i := range f.Folders {
+		tours = append(tours, collectTours(f.Folders[i])...)
+	}
+	return tours
+}
+
+// returns the names of the loaded tours
+func tourNames() []string {
+	tourPlayer.Lock()
+	defer tourPlayer.Unlock()
+
+	names := []string{}
+	for i := range tourPlayer.tours {
+		name := tourPlayer.tours[i].Name
+		if name == "" {
+			name = "Tour " + strconv.Itoa(i+1)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// selects the tour to play, rewinds it and ends the flight of the previous one
+func selectTour(i int) {
+	tourPlayer.Lock()
+	defer tourPlayer.Unlock()
+
+	if i < 0 || i >= len(tourPlayer.tours) {
+		return
+	}
+	tourPlayer.current = i
+	tourPlayer.index = 0
+	tourPlayer.elapsed = 0
+	tourPlayer.started = false
+	tourPlayer.playing = false
+	tourPlayer.stopFlight = true
+}
+
+// starts, pauses or resumes the selected tour
+func toggleTour() {
+	tourPlayer.Lock()
+	defer tourPlayer.Unlock()
+
+	if len(tourPlayer.tours) == 0 {
+		return
+	}
+	if !tourPlayer.playing && tourPlayer.index >= len(tourPlayer.tours[tourPlayer.current].Playlist.Items) {
+		tourPlayer.index = 0
+		tourPlayer.elapsed = 0
+		tourPlayer.started = false
+	}
+	tourPlayer.playing = !tourPlayer.playing
+}
+
+// stops the tour, rewinds it and leaves the camera where it is
+func stopTour() {
+	tourPlayer.Lock()
+	defer tourPlayer.Unlock()
+
+	tourPlayer.playing = false
+	tourPlayer.index = 0
+	tourPlayer.elapsed = 0
+	tourPlayer.started = false
+	tourPlayer.stopFlight = true
+}
+
+// advances the tour, called once per frame from the render loop
+func updateTour(deltaTime float64) {
+	changes := []visibilityChange{}
+
+	tourPlayer.Lock()
+	if tourPlayer.stopFlight {
+		tourPlayer.stopFlight = false
+		flight.active = false
+	}
+	if tourPlayer.playing {
+		items := tourPlayer.tours[tourPlayer.current].Playlist.Items
+		tourPlayer.elapsed += deltaTime
+
+		for tourPlayer.playing && tourPlayer.index < len(items) {
+			item := items[tourPlayer.index]
+
+			if !tourPlayer.started {
+				tourPlayer.started = true
+				switch item.XMLName.Local {
+				case "FlyTo":
+					if item.LookAt != nil {
+						flyTo(poseFromLookAt(*item.LookAt), item.Duration, item.FlyToMode == "smooth")
+					} else if item.Camera != nil {
+						flyTo(poseFromCamera(*item.Camera), item.Duration, item.FlyToMode == "smooth")
+					}
+				case "AnimatedUpdate":
+					changes = append(changes, updateChanges(item.Update)...)
+				case "TourControl":
+					if item.PlayMode == "pause" {
+						tourPlayer.playing = false
+					}
+				}
+			}
+
+			// flights and waits hold the playlist for their duration, other primitives don't
+			duration := 0.0
+			if item.XMLName.Local == "FlyTo" || item.XMLName.Local == "Wait" {
+				duration = item.Duration
+			}
+			if tourPlayer.elapsed < duration {
+				break
+			}
+
+			tourPlayer.elapsed -= duration
+			tourPlayer.index++
+			tourPlayer.started = false
+		}
+
+		if tourPlayer.index >= len(items) {
+			tourPlayer.playing = false
+		}
+		if !tourPlayer.playing {
+			tourPlayer.elapsed = 0
+		}
+	}
+	tourPlayer.Unlock()
+
+	for _, c := range changes {
+		setFeatureVisibility(c.id, c.visible)
+	}
+}
+
+// returns the visibility changes made by an <Update>. deleted features are hidden
+func updateChanges(u *Update) []visibilityChange {
+	changes := []visibilityChange{}
+	if u == nil {
+		return changes
+	}
+	for _, group := range u.Changes {
+		for _, t := range group.Targets {
+			if t.Visibility != nil {
+				changes = append(changes, visibilityChange{t.TargetID, *t.Visibility})
+			}
+		}
+	}
+	for _, group := range u.Deletes {
+		for _, t := range group.Targets {
+			changes = append(changes, visibilityChange{t.TargetID, false})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"sync"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// default length of a fly-to in seconds
+const flyToDuration = 3.0
+
+// cameraPose is the position and look direction of the FPS camera
+type cameraPose struct {
+	Pos   mgl32.Vec3
+	Yaw   float32
+	Pitch float32
+}
+
+// stores the camera animation started by flyTo
+var flight struct {
+	active   bool
+	from     cameraPose
+	to       cameraPose
+	elapsed  float64
+	duration float64
+	smooth   bool
+}
+
+// fly-to chosen in the gui, started by the render loop since it owns the camera and flight
+var queuedFlight struct {
+	sync.Mutex
+	pose    cameraPose
+	pending bool
+}
+
+// returns the model matrix used for the earth and kml objects at the current rotation
+func earthModel() mgl32.Mat4 {
+	return inertialModel().Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(float32(earthRotation())), mgl32.Vec3{0, 0, 1}))
+}
+
+// returns the model matrix for ECI positions, which turn with the view (angleZ) but not with the earth
+func inertialModel() mgl32.Mat4 {
+	return mgl32.HomogRotate3D(mgl32.DegToRad(-90.0), mgl32.Vec3{1, 0, 0}).
+		Mul4(mgl32.HomogRotate3D(mgl32.DegToRad(angleZ), mgl32.Vec3{0, 0, 1}))
+}
+
+// returns the camera front vector for a yaw and pitch in degrees
+func frontFromAngles(yaw float32, pitch float32) mgl32.Vec3 {
+	var front mgl32.Vec3
+	front[0] = float32(math.Cos(float64(mgl32.DegToRad(yaw))) * math.Cos(float64(mgl32.DegToRad(pitch))))
+	front[1] = float32(math.Sin(float64(mgl32.DegToRad(pitch))))
+	front[2] = float32(math.Sin(float64(mgl32.DegToRad(yaw))) * math.Cos(float64(mgl32.DegToRad(pitch))))
+	return front.Normalize()
+}
+
+// returns the east, north and up unit vectors at lat/lon (degrees) in model space
+func enuAxes(lat float64, lon float64) (mgl32.Vec3, mgl32.Vec3, mgl32.Vec3) {
+	latRad := lat * (math.Pi / 180)
+	lonRad := lon * (math.Pi / 180)
+
+	east := mgl32.Vec3{float32(-math.Sin(lonRad)), float32(math.Cos(lonRad)), 0}
+	north := mgl32.Vec3{
+		float32(-math.Sin(latRad) * math.Cos(lonRad)),
+		float32(-math.Sin(latRad) * math.Sin(lonRad)),
+		float32(math.Cos(latRad)),
+	}
+	up := mgl32.Vec3{
+		float32(math.Cos(latRad) * math.Cos(lonRad)),
+		float32(math.Cos(latRad) * math.Sin(lonRad)),
+		float32(math.Sin(latRad)),
+	}
+
+	return east, north, up
+}
+
+// converts a model space position and look direction into a world space camera pose.
+// the FPS camera has a fixed up vector, so KML roll is not representable
+func poseFromModel(pos mgl32.Vec3, front mgl32.Vec3) cameraPose {
+	model := earthModel()
+	pos = model.Mul4x1(pos.Vec4(1)).Vec3()
+	front = model.Mul4x1(front.Vec4(0)).Vec3().Normalize()
+
+	pitch := mgl32.RadToDeg(float32(math.Asin(float64(mgl32.Clamp(front[1], -1, 1)))))
+	yaw := mgl32.RadToDeg(float32(math.Atan2(float64(front[2]), float64(front[0]))))
+
+	return cameraPose{Pos: pos, Yaw: yaw, Pitch: mgl32.Clamp(pitch, -89.0, 89.0)}
+}
+
+// altitude in meters above the ellipsoid of a view at a position. views default to clamped to the ground
+func viewAltitude(lat float64, lon float64, alt float64, mode string) float64 {
+	if mode == "" {
+		mode = "clampToGround"
+	}
+	return altitudeAboveEllipsoid(lat, lon, alt, mode)
+}
+
+// returns the camera pose described by a KML <LookAt>
+func poseFromLookAt(l LookAt) cameraPose {
+	tx, ty, tz := latLonToVertex(l.Latitude, l.Longitude+frameLonOffset, viewAltitude(l.Latitude, l.Longitude+frameLonOffset, l.Altitude, l.AltitudeMode))
+	target := mgl32.Vec3{tx, ty, tz}
+
+	east, north, up := enuAxes(l.Latitude, l.Longitude+frameLonOffset)
+	heading := float64(mgl32.DegToRad(float32(l.Heading)))
+	tilt := float64(mgl32.DegToRad(float32(l.Tilt)))
+
+	// direction from the target back towards the camera
+	horizontal := north.Mul(float32(math.Cos(heading))).Add(east.Mul(float32(math.Sin(heading))))
+	toCamera := up.Mul(float32(math.Cos(tilt))).Sub(horizontal.Mul(float32(math.Sin(tilt))))
+
+	pos := target.Add(toCamera.Mul(float32(l.Range / a)))
+
+	return poseFromModel(pos, toCamera.Mul(-1))
+}
+
+// returns the camera pose described by a KML <Camera>
+func poseFromCamera(c CameraView) cameraPose {
+	px, py, pz := latLonToVertex(c.Latitude, c.Longitude+frameLonOffset, viewAltitude(c.Latitude, c.Longitude+frameLonOffset, c.Altitude, c.AltitudeMode))
+
+	east, north, up := enuAxes(c.Latitude, c.Longitude+frameLonOffset)
+	heading := float64(mgl32.DegToRad(float32(c.Heading)))
+	tilt := float64(mgl32.DegToRad(float32(c.Tilt)))
+
+	// tilt 0 looks straight down, tilt 90 looks at the horizon
+	horizontal := north.Mul(float32(math.Cos(heading))).Add(east.Mul(float32(math.Sin(heading))))
+	front := up.Mul(float32(-math.Cos(tilt))).Add(horizontal.Mul(float32(math.Sin(tilt))))
+
+	return poseFromModel(mgl32.Vec3{px, py, pz}, front)
+}
+
+// returns the view attached to a feature, preferring <LookAt> over <Camera>
+func featureView(f Folder) (cameraPose, bool) {
+	if f.LookAt != nil {
+		return poseFromLookAt(*f.LookAt), true
+	}
+	if f.Camera != nil {
+		return poseFromCamera(*f.Camera), true
+	}
+	return cameraPose{}, false
+}
+
+// returns the current camera pose
+func currentPose() cameraPose {
+	return cameraPose{Pos: camera.Pos, Yaw: mouse.yaw, Pitch: mouse.pitch}
+}
+
+// moves the camera to a pose immediately
+func setPose(p cameraPose) {
+	camera.Pos = p.Pos
+	camera.Up = mgl32.Vec3{0.0, 1.0, 0.0}
+	mouse.yaw = p.Yaw
+	mouse.pitch = p.Pitch
+	mouse.firstMouse = true
+	camera.Front = frontFromAngles(p.Yaw, p.Pitch)
+}
+
+// starts animating the camera from its current pose to p over duration seconds.
+// a smooth flight moves at constant speed instead of easing in and out (gx:flyToMode)
+func flyTo(p cameraPose, duration float64, smooth bool) {
+	if duration <= 0 {
+		flight.active = false
+		setPose(p)
+		return
+	}
+	flight.from = currentPose()
+	flight.to = p
+	flight.elapsed = 0
+	flight.duration = duration
+	flight.smooth = smooth
+	flight.active = true
+}
+
+// queues a fly-to pose to be flown to at the next frame, for the gui goroutine
+func queueFlyTo(p cameraPose) {
+	queuedFlight.Lock()
+	queuedFlight.pose, queuedFlight.pending = p, true
+	queuedFlight.Unlock()
+}
+
+// starts the fly-to queued by the gui, if there is one
+func flyToQueued() {
+	queuedFlight.Lock()
+	p, pending := queuedFlight.pose, queuedFlight.pending
+	queuedFlight.pending = false
+	queuedFlight.Unlock()
+	if pending {
+		flyTo(p, flyToDuration, false)
+	}
+}
+
+// advances the current fly-to, called once per frame from the render loop
+func updateFlight(deltaTime float64) {
+	if !flight.active {
+		return
+	}
+
+	flight.elapsed += deltaTime
+	t := float32(math.Min(flight.elapsed/flight.duration, 1.0))
+	if !flight.smooth {
+		// ease in and out
+		t = t * t * (3 - 2*t)
+	}
+
+	// interpolate direction and distance from the earth separately so the path arcs around the globe
+	fromDist, toDist := flight.from.Pos.Len(), flight.to.Pos.Len()
+	dir := slerp(flight.from.Pos.Normalize(), flight.to.Pos.Normalize(), t)
+	pos := dir.Mul(fromDist + (toDist-fromDist)*t)
+
+	// take the short way around when interpolating yaw
+	dYaw := float32(math.Mod(float64(flight.to.Yaw-flight.from.Yaw)+540, 360) - 180)
+
+	setPose(cameraPose{
+		Pos:   pos,
+		Yaw:   flight.from.Yaw + dYaw*t,
+		Pitch: flight.from.Pitch + (flight.to.Pitch-flight.from.Pitch)*t,
+	})
+
+	if flight.elapsed >= flight.duration {
+		flight.active = false
+	}
+}
+
+// spherical interpolation between two unit vectors
+func slerp(from mgl32.Vec3, to mgl32.Vec3, t float32) mgl32.Vec3 {
+	dot := float64(mgl32.Clamp(from.Dot(to), -1, 1))
+	theta := math.Acos(dot)
+	if theta < 1e-4 || math.Pi-theta < 1e-4 {
+		v := from.Mul(1 - t).Add(to.Mul(t))
+		if v.Len() < 1e-4 {
+			return from
+		}
+		return v.Normalize()
+	}
+	s := math.Sin(theta)
+	w1 := float32(math.Sin((1-float64(t))*theta) / s)
+	w2 := float32(math.Sin(float64(t)*theta) / s)
+	return from.Mul(w1).Add(to.Mul(w2))
+}